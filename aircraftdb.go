@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AircraftInfo is the reference data for an airframe from an offline aircraft database.
+type AircraftInfo struct {
+	Registration string
+	Manufacturer string
+	Type         string // ICAO type designator
+	Model        string
+	Operator     string
+	Year         string
+}
+
+type aircraftRecord struct {
+	Icao uint
+	Info AircraftInfo
+}
+
+// enrichPlane adds the reference data for the plane's ICAO, if known, and the
+// country the address is allocated to. If the registration is not known it is
+// derived from the address where possible. The route is looked up from the callsign.
+func enrichPlane(pl *Plane) {
+	info, err := LoadAircraftInfo(pl.Icao)
+	if err != nil && err != aircraftNotFound {
+		fmt.Fprintf(os.Stderr, "error loading aircraft info: %v\n", err)
+	}
+	if info != nil {
+		pl.Info = *info
+	}
+
+	addDerivedInfo(pl)
+	pl.Route = findRoute(pl.CallSign)
+}
+
+// addDerivedInfo adds the country and registration derived from the plane's address,
+// for planes whose reference data has already been loaded.
+func addDerivedInfo(pl *Plane) {
+	if r := icaoCountry(pl.Icao); r != nil {
+		pl.Country = r.Country
+		pl.Flag = r.Flag
+	}
+	pl.Military = icaoIsMilitary(pl.Icao)
+
+	if pl.Info.Registration == "" {
+		pl.Info.Registration = icaoToRegistration(pl.Icao)
+	}
+}
+
+// importAircraft is the import-aircraft sub command. It loads an aircraft database
+// into the Aircraft table, replacing the entries from any earlier import of the same format.
+func importAircraft(args []string) error {
+	fs := flag.NewFlagSet("import-aircraft", flag.ExitOnError)
+	format := fs.String("format", "", "Format of the file: basestation, tar1090 or faa. Guessed from the file name if not set.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import-aircraft [-format basestation|tar1090|faa] <file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing aircraft database file")
+	}
+	file := fs.Arg(0)
+
+	if *format == "" {
+		*format = guessAircraftFormat(file)
+	}
+
+	var recs []aircraftRecord
+	var err error
+	switch *format {
+	case "basestation":
+		recs, err = readBaseStation(file)
+	case "tar1090":
+		recs, err = readTar1090(file)
+	case "faa":
+		recs, err = readFaaMaster(file)
+	default:
+		return fmt.Errorf("unknown aircraft database format: %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = SaveAircraft(recs, *format)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d aircraft from %s\n", len(recs), file)
+	return nil
+}
+
+func guessAircraftFormat(file string) string {
+	name := strings.ToLower(filepath.Base(file))
+	switch {
+	case strings.HasSuffix(name, ".sqb"):
+		return "basestation"
+	case strings.HasPrefix(name, "master"):
+		return "faa"
+	default:
+		return "tar1090"
+	}
+}
+
+func parseHexIcao(s string) (uint, bool) {
+	icao, err := strconv.ParseUint(strings.TrimSpace(s), 16, 32)
+	if err != nil || icao == 0 {
+		return 0, false
+	}
+	return uint(icao), true
+}
+
+// readBaseStation reads the Aircraft table of a Kinetic BaseStation.sqb database.
+func readBaseStation(file string) ([]aircraftRecord, error) {
+	bs, err := sql.Open("sqlite3", "file:"+file+"?mode=ro")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open BaseStation database")
+	}
+	defer bs.Close()
+
+	rows, err := bs.Query(`SELECT ModeS, Registration, Manufacturer, ICAOTypeCode, Type, RegisteredOwners, YearBuilt FROM Aircraft`)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query BaseStation Aircraft table")
+	}
+	defer rows.Close()
+
+	var recs []aircraftRecord
+	for rows.Next() {
+		var modeS, reg, mfr, typ, model, owner, year sql.NullString
+		err = rows.Scan(&modeS, &reg, &mfr, &typ, &model, &owner, &year)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read BaseStation aircraft")
+		}
+
+		icao, ok := parseHexIcao(modeS.String)
+		if !ok {
+			continue
+		}
+		recs = append(recs, aircraftRecord{Icao: icao, Info: AircraftInfo{
+			Registration: strings.TrimSpace(reg.String),
+			Manufacturer: strings.TrimSpace(mfr.String),
+			Type:         strings.TrimSpace(typ.String),
+			Model:        strings.TrimSpace(model.String),
+			Operator:     strings.TrimSpace(owner.String),
+			Year:         strings.TrimSpace(year.String),
+		}})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over BaseStation aircraft")
+	}
+
+	return recs, nil
+}
+
+// openMaybeGzip opens the file, decompressing it if it ends in .gz.
+func openMaybeGzip(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(file, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{gz, f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// readTar1090 reads the tar1090-db aircraft.csv(.gz) file. Each line is
+// icao;registration;type;flags;description;year;owner. The manufacturer is taken
+// from the first word of the description.
+func readTar1090(file string) ([]aircraftRecord, error) {
+	f, err := openMaybeGzip(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open tar1090 database")
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = ';'
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	var recs []aircraftRecord
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "unable to read tar1090 database")
+		}
+		if len(fields) < 3 {
+			continue
+		}
+
+		icao, ok := parseHexIcao(fields[0])
+		if !ok {
+			continue
+		}
+		rec := aircraftRecord{Icao: icao}
+		rec.Info.Registration = strings.TrimSpace(fields[1])
+		rec.Info.Type = strings.TrimSpace(fields[2])
+		if len(fields) > 4 {
+			rec.Info.Model = strings.TrimSpace(fields[4])
+			if words := strings.Fields(rec.Info.Model); len(words) > 0 {
+				rec.Info.Manufacturer = words[0]
+			}
+		}
+		if len(fields) > 5 {
+			rec.Info.Year = strings.TrimSpace(fields[5])
+		}
+		if len(fields) > 6 {
+			rec.Info.Operator = strings.TrimSpace(fields[6])
+		}
+		recs = append(recs, rec)
+	}
+
+	return recs, nil
+}
+
+// csvHeader maps the trimmed, upper case column names of a header row to their index.
+func csvHeader(fields []string) map[string]int {
+	h := make(map[string]int)
+	for i, f := range fields {
+		h[strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(f, "\ufeff")))] = i
+	}
+	return h
+}
+
+func csvField(fields []string, h map[string]int, name string) string {
+	i, ok := h[name]
+	if !ok || i >= len(fields) {
+		return ""
+	}
+	return strings.TrimSpace(fields[i])
+}
+
+// readFaaMaster reads the FAA releasable aircraft MASTER.txt. If ACFTREF.txt is in the
+// same directory it is used for the manufacturer and model.
+func readFaaMaster(file string) ([]aircraftRecord, error) {
+	models, err := readFaaAircraftRef(filepath.Join(filepath.Dir(file), "ACFTREF.txt"))
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open FAA master file")
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read FAA master header")
+	}
+	h := csvHeader(header)
+
+	var recs []aircraftRecord
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "unable to read FAA master file")
+		}
+
+		icao, ok := parseHexIcao(csvField(fields, h, "MODE S CODE HEX"))
+		if !ok {
+			continue
+		}
+		rec := aircraftRecord{Icao: icao}
+		rec.Info.Registration = "N" + csvField(fields, h, "N-NUMBER")
+		rec.Info.Operator = csvField(fields, h, "NAME")
+		rec.Info.Year = csvField(fields, h, "YEAR MFR")
+		if m, ok := models[csvField(fields, h, "MFR MDL CODE")]; ok {
+			rec.Info.Manufacturer = m[0]
+			rec.Info.Model = m[1]
+		}
+		recs = append(recs, rec)
+	}
+
+	return recs, nil
+}
+
+// readFaaAircraftRef reads the manufacturer and model for each code in ACFTREF.txt.
+func readFaaAircraftRef(file string) (map[string][2]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open FAA aircraft reference file")
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read FAA aircraft reference header")
+	}
+	h := csvHeader(header)
+
+	models := make(map[string][2]string)
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "unable to read FAA aircraft reference file")
+		}
+		models[csvField(fields, h, "CODE")] = [2]string{csvField(fields, h, "MFR"), csvField(fields, h, "MODEL")}
+	}
+
+	return models, nil
+}
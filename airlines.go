@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Airline is an operator from the local airline table.
+type Airline struct {
+	Icao     string // ICAO 3 letter designator
+	Iata     string
+	Name     string
+	Callsign string // Radio telephony callsign
+}
+
+// flightRecord is a callsign a stored plane has used.
+type flightRecord struct {
+	Icao     uint
+	CallSign string
+	LastSeen time.Time
+}
+
+// Flight is a callsign decoded into its operator and flight number.
+type Flight struct {
+	Operator string
+	Number   string
+	Airline  *Airline
+}
+
+// IataFlight returns the IATA form of the flight number (UA123), or an empty string if
+// the operator has no IATA code.
+func (f *Flight) IataFlight() string {
+	if f.Airline == nil || f.Airline.Iata == "" {
+		return ""
+	}
+	return f.Airline.Iata + f.Number
+}
+
+func (f *Flight) ToJson() string {
+	var name, radio string
+	if f.Airline != nil {
+		name = f.Airline.Name
+		radio = f.Airline.Callsign
+	}
+	return fmt.Sprintf("{\"operator\": %q, \"number\": %q, \"airline\": %q, \"radio\": %q, \"iata\": %q}", f.Operator, f.Number, name, radio, f.IataFlight())
+}
+
+var (
+	airlines = make(map[string]*Airline)
+	// ICAO designators for each IATA code. IATA codes are not unique.
+	iataAirlines = make(map[string][]string)
+)
+
+// loadAirlines reads the Airlines table into memory.
+func loadAirlines() error {
+	al, err := LoadAirlines()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range al {
+		airlines[a.Icao] = a
+		if a.Iata != "" {
+			iataAirlines[a.Iata] = append(iataAirlines[a.Iata], a.Icao)
+		}
+	}
+
+	return nil
+}
+
+// decodeCallsign splits a callsign such as "UAL123" into the operator designator and flight
+// number. Returns nil if the callsign is not in that form, such as a registration.
+func decodeCallsign(cs string) *Flight {
+	cs = strings.ToUpper(strings.TrimSpace(cs))
+	if len(cs) < 4 || len(cs) > 8 {
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		if cs[i] < 'A' || cs[i] > 'Z' {
+			return nil
+		}
+	}
+
+	num := cs[3:]
+	if num[0] < '0' || num[0] > '9' {
+		return nil
+	}
+	for i := 0; i < len(num); i++ {
+		c := num[i]
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'Z') {
+			return nil
+		}
+	}
+
+	num = strings.TrimLeft(num, "0")
+	if num == "" || num[0] < '0' || num[0] > '9' {
+		return nil
+	}
+
+	return &Flight{Operator: cs[:3], Number: num, Airline: airlines[cs[:3]]}
+}
+
+// flightCallsigns returns the ICAO callsigns a flight number in either ICAO (UAL123) or
+// IATA (UA123) form may be seen as.
+func flightCallsigns(flight string) []string {
+	flight = strings.ToUpper(strings.TrimSpace(flight))
+
+	var result []string
+	if f := decodeCallsign(flight); f != nil {
+		result = append(result, f.Operator+f.Number)
+		if f.Airline != nil || len(iataAirlines) == 0 {
+			return result
+		}
+	}
+
+	if len(flight) > 2 && flight[2] >= '0' && flight[2] <= '9' {
+		num := strings.TrimLeft(flight[2:], "0")
+		for _, icao := range iataAirlines[flight[:2]] {
+			result = append(result, icao+num)
+		}
+	}
+
+	return result
+}
+
+// searchFlights finds the planes that have flown as the flight number.
+func searchFlights(flight string) string {
+	callsigns := flightCallsigns(flight)
+	if len(callsigns) == 0 {
+		return "[]"
+	}
+
+	seen := make(map[string]bool)
+	var found []flightRecord
+	var active []bool
+	for _, pl := range planeCache {
+		for _, cs := range pl.CallSigns {
+			if f := decodeCallsign(cs.value); f != nil && containsString(callsigns, f.Operator+f.Number) {
+				found = append(found, flightRecord{Icao: pl.Icao, CallSign: cs.value, LastSeen: pl.LastSeen})
+				active = append(active, true)
+				seen[fmt.Sprintf("%06X|%s", pl.Icao, cs.value)] = true
+			}
+		}
+	}
+
+	flights, err := LoadFlights(callsigns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error searching flights: %v\n", err)
+	}
+	for _, f := range flights {
+		if seen[fmt.Sprintf("%06X|%s", f.Icao, f.CallSign)] {
+			continue
+		}
+		found = append(found, f)
+		active = append(active, false)
+	}
+
+	// Look up the routes of all the callsigns at once.
+	var names []string
+	for _, f := range found {
+		names = append(names, strings.ToUpper(f.CallSign))
+	}
+	routes, err := LoadRoutes(names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading routes: %v\n", err)
+	}
+
+	sl := make([]string, len(found))
+	for i, f := range found {
+		route := "null"
+		if r := routes[strings.ToUpper(f.CallSign)]; r != nil {
+			route = r.ToJson(false)
+		}
+		sl[i] = fmt.Sprintf("{\"icao\": \"%06X\", \"callsign\": %q, \"route\": %s, \"lastSeen\": %q, \"active\": %v}", f.Icao, f.CallSign, route, f.LastSeen.String(), active[i])
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
+
+// importAirlines is the import-airlines sub command. It accepts a CSV with a header
+// containing icao, iata, name and callsign columns, or the OpenFlights airlines.dat file.
+func importAirlines(args []string) error {
+	fs := flag.NewFlagSet("import-airlines", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import-airlines <file>\n", os.Args[0])
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing airlines file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return errors.Wrap(err, "unable to open airlines file")
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	var al []*Airline
+	var h map[string]int
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "unable to read airlines file")
+		}
+
+		if h == nil {
+			h = csvHeader(fields)
+			if _, ok := h["ICAO"]; ok {
+				continue
+			}
+			// OpenFlights: id, name, alias, IATA, ICAO, callsign, country, active
+			h = map[string]int{"NAME": 1, "IATA": 3, "ICAO": 4, "CALLSIGN": 5}
+		}
+
+		a := &Airline{
+			Icao:     strings.ToUpper(csvField(fields, h, "ICAO")),
+			Iata:     strings.ToUpper(csvField(fields, h, "IATA")),
+			Name:     csvField(fields, h, "NAME"),
+			Callsign: csvField(fields, h, "CALLSIGN"),
+		}
+		if len(a.Icao) != 3 {
+			continue
+		}
+		if a.Iata == "-" || a.Iata == "\\N" || len(a.Iata) != 2 {
+			a.Iata = ""
+		}
+		if a.Callsign == "\\N" {
+			a.Callsign = ""
+		}
+		al = append(al, a)
+	}
+
+	err = SaveAirlines(al)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d airlines from %s\n", len(al), fs.Arg(0))
+	return nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Airport struct {
+	Ident     string // ICAO code, or local identifier if there is none
+	Iata      string
+	Name      string
+	Latitude  float64
+	Longitude float64
+	Elevation int
+	Country   string
+	Runways   []*Runway
+}
+
+func (a *Airport) ToJson() string {
+	return fmt.Sprintf("{\"ident\": %q, \"iata\": %q, \"name\": %q, \"location\": \"%f,%f\", %s}",
+		a.Ident, a.Iata, a.Name, a.Latitude, a.Longitude, units.altitude("elevation", a.Elevation))
+}
+
+// DetailJson returns the airport with its runways.
+func (a *Airport) DetailJson() string {
+	sl := make([]string, len(a.Runways))
+	for i, r := range a.Runways {
+		sl[i] = r.ToJson()
+	}
+	return fmt.Sprintf("{\"ident\": %q, \"iata\": %q, \"name\": %q, \"location\": \"%f,%f\", %s, \"country\": %q, \"runways\": [%s]}",
+		a.Ident, a.Iata, a.Name, a.Latitude, a.Longitude, units.altitude("elevation", a.Elevation), a.Country, strings.Join(sl, ", "))
+}
+
+// RunwayEnd is one direction of a runway. The location is that of the threshold, and
+// is zero if it is not known.
+type RunwayEnd struct {
+	Ident     string
+	Latitude  float64
+	Longitude float64
+	Heading   float64 // degrees true
+}
+
+// Runway is a runway of an airport, with its low and high numbered ends.
+type Runway struct {
+	Airport string
+	Length  int
+	Ends    [2]RunwayEnd
+}
+
+// Ident returns the name of the runway from both ends, such as 10L/28R.
+func (r *Runway) Ident() string {
+	return r.Ends[0].Ident + "/" + r.Ends[1].Ident
+}
+
+// HasLocation returns true if the threshold of both ends is known.
+func (r *Runway) HasLocation() bool {
+	return (r.Ends[0].Latitude != 0 || r.Ends[0].Longitude != 0) && (r.Ends[1].Latitude != 0 || r.Ends[1].Longitude != 0)
+}
+
+func (r *Runway) ToJson() string {
+	return fmt.Sprintf("{\"ident\": %q, %s, \"headings\": [%.0f, %.0f]}", r.Ident(), units.altitude("length", r.Length), r.Ends[0].Heading, r.Ends[1].Heading)
+}
+
+var (
+	airports     = make(map[string]*Airport)
+	airportList  []*Airport
+	airportIndex = newGridIndex()
+)
+
+// loadAirports reads the Airports table into memory.
+func loadAirports() error {
+	al, err := LoadAirports()
+	if err != nil {
+		return err
+	}
+
+	rl, err := LoadRunways()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range al {
+		a.Runways = rl[a.Ident]
+		airports[a.Ident] = a
+
+		// Index the area around the airport in which movements are detected.
+		dLat := movementRadius / 60
+		dLon := dLat / math.Max(math.Cos(toRad(a.Latitude)), 0.01)
+		airportIndex.Insert(len(airportList), point{Lat: a.Latitude - dLat, Lon: a.Longitude - dLon},
+			point{Lat: a.Latitude + dLat, Lon: a.Longitude + dLon})
+		airportList = append(airportList, a)
+	}
+
+	if verbose {
+		fmt.Printf("Loaded %d airports\n", len(airportList))
+	}
+
+	return nil
+}
+
+// nearbyAirports returns the airports within the movement radius of the position,
+// nearest first.
+func nearbyAirports(lat, lon float64) []*Airport {
+	var found []*Airport
+	var dists []float64
+	for _, id := range airportIndex.Query(lat, lon) {
+		a := airportList[id]
+		d := distanceNm(lat, lon, a.Latitude, a.Longitude)
+		if d > movementRadius {
+			continue
+		}
+		i := len(found)
+		for i > 0 && dists[i-1] > d {
+			i--
+		}
+		found = append(found[:i], append([]*Airport{a}, found[i:]...)...)
+		dists = append(dists[:i], append([]float64{d}, dists[i:]...)...)
+	}
+	return found
+}
+
+// groundElevation returns the elevation in feet of the nearest airport indexed around
+// the position, as an estimate of the ground level there. Returns 0 if there is none.
+func groundElevation(lat, lon float64) int {
+	elev, best := 0, -1.0
+	for _, id := range airportIndex.Query(lat, lon) {
+		a := airportList[id]
+		if d := distanceNm(lat, lon, a.Latitude, a.Longitude); best < 0 || d < best {
+			elev, best = a.Elevation, d
+		}
+	}
+	return elev
+}
+
+// runwayHeading returns the heading of a runway end from its ident, such as 28L, for
+// when the heading is not in the data.
+func runwayHeading(ident string) float64 {
+	n := strings.TrimRight(ident, "LCRW")
+	h, err := strconv.Atoi(n)
+	if err != nil {
+		return -1
+	}
+	return float64(h * 10 % 360)
+}
+
+// getAirport returns the airport with its runways.
+func getAirport(ident string) string {
+	a, ok := airports[strings.ToUpper(ident)]
+	if !ok {
+		return "null"
+	}
+	return a.DetailJson()
+}
+
+// csvFiles returns the CSV files in each path, recursing into directories.
+func csvFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			if path == p || strings.EqualFold(filepath.Ext(path), ".csv") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// readCsvFile calls fn with each row of a CSV file with a header row.
+func readCsvFile(file string, fn func(fields []string, h map[string]int) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "unable to open file")
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read header of %q", file))
+	}
+	h := csvHeader(header)
+
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to read %q", file))
+		}
+		err = fn(fields, h)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// importAirports is the import-airports sub command. It reads airports files from the
+// standing data (Code, Name, ICAO, IATA, Location, CountryISO2, Latitude, Longitude,
+// AltitudeFeet), either single files or directories of them, or the OurAirports
+// airports.csv and runways.csv files. The format is found from the header of each file.
+func importAirports(args []string) error {
+	fs := flag.NewFlagSet("import-airports", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import-airports <file or directory>...\n", os.Args[0])
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing airports file")
+	}
+
+	files, err := csvFiles(fs.Args())
+	if err != nil {
+		return errors.Wrap(err, "unable to find airports files")
+	}
+
+	var al []*Airport
+	var rl []*Runway
+	for _, file := range files {
+		err = readCsvFile(file, func(fields []string, h map[string]int) error {
+			if _, ok := h["AIRPORT_IDENT"]; ok {
+				if r := readOurAirportsRunway(fields, h); r != nil {
+					rl = append(rl, r)
+				}
+				return nil
+			}
+			if _, ok := h["LATITUDE_DEG"]; ok {
+				if a := readOurAirportsAirport(fields, h); a != nil {
+					al = append(al, a)
+				}
+				return nil
+			}
+
+			a := &Airport{
+				Ident:   strings.ToUpper(csvField(fields, h, "ICAO")),
+				Iata:    strings.ToUpper(csvField(fields, h, "IATA")),
+				Name:    csvField(fields, h, "NAME"),
+				Country: csvField(fields, h, "COUNTRYISO2"),
+			}
+			if a.Ident == "" {
+				a.Ident = strings.ToUpper(csvField(fields, h, "CODE"))
+			}
+			a.Latitude, _ = strconv.ParseFloat(csvField(fields, h, "LATITUDE"), 64)
+			a.Longitude, _ = strconv.ParseFloat(csvField(fields, h, "LONGITUDE"), 64)
+			a.Elevation, _ = strconv.Atoi(csvField(fields, h, "ALTITUDEFEET"))
+			if a.Ident != "" {
+				al = append(al, a)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = SaveAirports(al)
+	if err != nil {
+		return err
+	}
+	err = SaveRunways(rl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d airports and %d runways\n", len(al), len(rl))
+	return nil
+}
+
+// readOurAirportsAirport reads a row of the OurAirports airports.csv. Closed airports
+// are skipped.
+func readOurAirportsAirport(fields []string, h map[string]int) *Airport {
+	if csvField(fields, h, "TYPE") == "closed" {
+		return nil
+	}
+
+	a := &Airport{
+		Ident:   strings.ToUpper(csvField(fields, h, "IDENT")),
+		Iata:    strings.ToUpper(csvField(fields, h, "IATA_CODE")),
+		Name:    csvField(fields, h, "NAME"),
+		Country: csvField(fields, h, "ISO_COUNTRY"),
+	}
+	if a.Ident == "" {
+		return nil
+	}
+	a.Latitude, _ = strconv.ParseFloat(csvField(fields, h, "LATITUDE_DEG"), 64)
+	a.Longitude, _ = strconv.ParseFloat(csvField(fields, h, "LONGITUDE_DEG"), 64)
+	a.Elevation, _ = strconv.Atoi(csvField(fields, h, "ELEVATION_FT"))
+
+	return a
+}
+
+// readOurAirportsRunway reads a row of the OurAirports runways.csv. Closed runways are
+// skipped. Missing headings are taken from the thresholds, or else the runway number.
+func readOurAirportsRunway(fields []string, h map[string]int) *Runway {
+	if csvField(fields, h, "CLOSED") == "1" {
+		return nil
+	}
+
+	r := &Runway{Airport: strings.ToUpper(csvField(fields, h, "AIRPORT_IDENT"))}
+	r.Length, _ = strconv.Atoi(csvField(fields, h, "LENGTH_FT"))
+	for i, prefix := range []string{"LE_", "HE_"} {
+		e := &r.Ends[i]
+		e.Ident = strings.ToUpper(csvField(fields, h, prefix+"IDENT"))
+		e.Latitude, _ = strconv.ParseFloat(csvField(fields, h, prefix+"LATITUDE_DEG"), 64)
+		e.Longitude, _ = strconv.ParseFloat(csvField(fields, h, prefix+"LONGITUDE_DEG"), 64)
+		hdg, err := strconv.ParseFloat(csvField(fields, h, prefix+"HEADING_DEGT"), 64)
+		if err != nil {
+			hdg = -1
+		}
+		e.Heading = hdg
+	}
+	if r.Airport == "" || r.Ends[0].Ident == "" {
+		return nil
+	}
+
+	for i := range r.Ends {
+		if r.Ends[i].Heading >= 0 {
+			continue
+		}
+		if r.HasLocation() {
+			o := r.Ends[1-i]
+			r.Ends[i].Heading = bearing(r.Ends[i].Latitude, r.Ends[i].Longitude, o.Latitude, o.Longitude)
+		} else {
+			r.Ends[i].Heading = runwayHeading(r.Ends[i].Ident)
+		}
+	}
+
+	return r
+}
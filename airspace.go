@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Airspace classes which raise an event when entered.
+var eventClasses = map[string]bool{
+	"B": true,
+	"C": true,
+	"D": true,
+	"R": true,
+	"P": true,
+}
+
+const (
+	// Degrees between the points generated for arcs and circles.
+	arcStep = 2.0
+	// Value used for unlimited ceilings.
+	unlimitedAltitude = 999999
+)
+
+// Airspace is a single airspace definition. Floor and Ceiling are in feet, above ground
+// level if FloorAgl or CeilingAgl is set.
+//
+// As there is no terrain model, the ground level is taken to be the elevation of the
+// nearest airport, or sea level if there is none nearby.
+type Airspace struct {
+	Name       string
+	Class      string
+	Floor      int
+	FloorAgl   bool
+	Ceiling    int
+	CeilingAgl bool
+	Poly       polygon
+}
+
+func (a *Airspace) Contains(lat, lon float64, alt int) bool {
+	floor, ceiling := a.Floor, a.Ceiling
+	if a.FloorAgl || a.CeilingAgl {
+		if !a.Poly.Contains(lat, lon) {
+			return false
+		}
+		ground := groundElevation(lat, lon)
+		if a.FloorAgl {
+			floor += ground
+		}
+		if a.CeilingAgl {
+			ceiling += ground
+		}
+		return alt >= floor && alt <= ceiling
+	}
+	if alt < floor || alt > ceiling {
+		return false
+	}
+	return a.Poly.Contains(lat, lon)
+}
+
+// Label is the name of the airspace as shown on a Plane.
+func (a *Airspace) Label() string {
+	return fmt.Sprintf("%s (%s)", a.Name, a.Class)
+}
+
+type AirspaceEvent struct {
+	id        int
+	Icao      uint
+	Time      time.Time
+	Airspace  string
+	Class     string
+	CallSign  string
+	Squawk    string
+	Latitude  float32
+	Longitude float32
+	Altitude  int
+	Incursion bool
+}
+
+func (e *AirspaceEvent) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", e.id))
+	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", e.Icao))
+	buf.WriteString(fmt.Sprintf("\"time\": %q, ", e.Time.String()))
+	buf.WriteString(fmt.Sprintf("\"airspace\": %q, ", e.Airspace))
+	buf.WriteString(fmt.Sprintf("\"class\": %q, ", e.Class))
+	buf.WriteString(fmt.Sprintf("\"callsign\": %q, ", e.CallSign))
+	buf.WriteString(fmt.Sprintf("\"squawk\": %q, ", e.Squawk))
+	buf.WriteString(fmt.Sprintf("\"location\": \"%f,%f\", ", e.Latitude, e.Longitude))
+	buf.WriteString(units.altitude("altitude", e.Altitude) + ", ")
+	buf.WriteString(fmt.Sprintf("\"incursion\": %v", e.Incursion))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+var (
+	airspaces     []*Airspace
+	airspaceIndex = newGridIndex()
+	// Squawks which show the aircraft is not talking to ATC.
+	uncontrolledSquawks = make(map[string]bool)
+)
+
+// loadAirspace reads each comma separated OpenAir file and indexes the airspace found.
+func loadAirspace(files string) error {
+	for _, sq := range strings.Split(vfrSquawks, ",") {
+		if sq = strings.TrimSpace(sq); sq != "" {
+			uncontrolledSquawks[sq] = true
+		}
+	}
+
+	if files == "" {
+		return nil
+	}
+
+	for _, file := range strings.Split(files, ",") {
+		f, err := os.Open(strings.TrimSpace(file))
+		if err != nil {
+			return errors.Wrap(err, "unable to open airspace file")
+		}
+
+		as, err := parseOpenAir(f)
+		f.Close()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to parse airspace file %q", file))
+		}
+
+		for _, a := range as {
+			if len(a.Poly) == 0 || len(a.Poly[0]) < 3 {
+				continue
+			}
+			min, max := a.Poly.Bounds()
+			airspaceIndex.Insert(len(airspaces), min, max)
+			airspaces = append(airspaces, a)
+		}
+	}
+
+	if verbose {
+		fmt.Printf("Loaded %d airspaces\n", len(airspaces))
+	}
+
+	return nil
+}
+
+// openAirState holds the values of the OpenAir variables while parsing.
+type openAirState struct {
+	center    point
+	clockwise bool
+}
+
+func parseOpenAir(r io.Reader) ([]*Airspace, error) {
+	var result []*Airspace
+	var cur *Airspace
+	var ring []point
+	st := openAirState{clockwise: true}
+
+	finish := func() {
+		if cur != nil && len(ring) > 0 {
+			if ring[0] != ring[len(ring)-1] {
+				ring = append(ring, ring[0])
+			}
+			cur.Poly = polygon{ring}
+			result = append(result, cur)
+		}
+		cur = nil
+		ring = nil
+		st = openAirState{clockwise: true}
+	}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '*' {
+			continue
+		}
+		if i := strings.Index(text, "*"); i > 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+
+		parts := strings.SplitN(text, " ", 2)
+		cmd := strings.ToUpper(parts[0])
+		var arg string
+		if len(parts) > 1 {
+			arg = strings.TrimSpace(parts[1])
+		}
+
+		if cmd == "AC" {
+			finish()
+			cur = &Airspace{Class: strings.ToUpper(arg), Ceiling: unlimitedAltitude}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+
+		var err error
+		switch cmd {
+		case "AN":
+			cur.Name = arg
+		case "AL":
+			cur.Floor, cur.FloorAgl, err = parseOpenAirLimit(arg)
+		case "AH":
+			cur.Ceiling, cur.CeilingAgl, err = parseOpenAirLimit(arg)
+		case "V":
+			err = st.setVariable(arg)
+		case "DP":
+			var p point
+			p, err = parseOpenAirCoord(arg)
+			ring = append(ring, p)
+		case "DC":
+			var r float64
+			r, err = strconv.ParseFloat(arg, 64)
+			ring = append(ring, arcPoints(st.center, r, 0, 360, true)...)
+		case "DA":
+			ring, err = st.addArc(ring, arg)
+		case "DB":
+			ring, err = st.addArcBetween(ring, arg)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("line %d", line))
+		}
+	}
+	finish()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (st *openAirState) setVariable(arg string) error {
+	kv := strings.SplitN(arg, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("invalid variable: %q", arg)
+	}
+
+	switch strings.ToUpper(strings.TrimSpace(kv[0])) {
+	case "X":
+		p, err := parseOpenAirCoord(kv[1])
+		if err != nil {
+			return err
+		}
+		st.center = p
+	case "D":
+		st.clockwise = strings.TrimSpace(kv[1]) != "-"
+	}
+	return nil
+}
+
+// addArc adds the points of a "DA radius, start, end" arc around the current center.
+func (st *openAirState) addArc(ring []point, arg string) ([]point, error) {
+	vals := strings.Split(arg, ",")
+	if len(vals) != 3 {
+		return ring, fmt.Errorf("invalid arc: %q", arg)
+	}
+
+	var f [3]float64
+	for i, v := range vals {
+		var err error
+		f[i], err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return ring, errors.Wrap(err, "invalid arc value")
+		}
+	}
+
+	return append(ring, arcPoints(st.center, f[0], f[1], f[2], st.clockwise)...), nil
+}
+
+// addArcBetween adds the points of a "DB coord1, coord2" arc around the current center.
+func (st *openAirState) addArcBetween(ring []point, arg string) ([]point, error) {
+	vals := strings.Split(arg, ",")
+	if len(vals) != 2 {
+		return ring, fmt.Errorf("invalid arc: %q", arg)
+	}
+
+	p1, err := parseOpenAirCoord(vals[0])
+	if err != nil {
+		return ring, err
+	}
+	p2, err := parseOpenAirCoord(vals[1])
+	if err != nil {
+		return ring, err
+	}
+
+	c := st.center
+	r := distanceNm(c.Lat, c.Lon, p1.Lat, p1.Lon)
+	start := bearing(c.Lat, c.Lon, p1.Lat, p1.Lon)
+	end := bearing(c.Lat, c.Lon, p2.Lat, p2.Lon)
+
+	ring = append(ring, p1)
+	ring = append(ring, arcPoints(c, r, start, end, st.clockwise)...)
+	return append(ring, p2), nil
+}
+
+// arcPoints returns points along an arc of radius r (nm) around c, from the start
+// bearing to the end bearing.
+func arcPoints(c point, r, start, end float64, clockwise bool) []point {
+	sweep := math.Mod(end-start+360, 360)
+	if !clockwise {
+		sweep = math.Mod(start-end+360, 360)
+	}
+	if sweep == 0 {
+		sweep = 360
+	}
+
+	var pts []point
+	for a := 0.0; a <= sweep; a += arcStep {
+		brg := start + a
+		if !clockwise {
+			brg = start - a
+		}
+		lat, lon := destination(c.Lat, c.Lon, brg, r)
+		pts = append(pts, point{Lat: lat, Lon: lon})
+	}
+	return pts
+}
+
+// parseOpenAirCoord parses coordinates such as "39:29.9 N 119:46.1 W" or "39:29:54N 119:46:06W".
+func parseOpenAirCoord(s string) (point, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexAny(s, "NS")
+	if i < 0 {
+		return point{}, fmt.Errorf("invalid coordinate: %q", s)
+	}
+
+	lat, err := parseDMS(s[:i])
+	if err != nil {
+		return point{}, err
+	}
+	if s[i] == 'S' {
+		lat = -lat
+	}
+
+	rest := strings.TrimSpace(s[i+1:])
+	j := strings.IndexAny(rest, "EW")
+	if j < 0 {
+		return point{}, fmt.Errorf("invalid coordinate: %q", s)
+	}
+	lon, err := parseDMS(rest[:j])
+	if err != nil {
+		return point{}, err
+	}
+	if rest[j] == 'W' {
+		lon = -lon
+	}
+
+	return point{Lat: lat, Lon: lon}, nil
+}
+
+// parseDMS converts "DD:MM:SS.s" or "DD:MM.m" to decimal degrees.
+func parseDMS(s string) (float64, error) {
+	var val float64
+	div := 1.0
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("invalid coordinate: %q", s))
+		}
+		val += f / div
+		div *= 60
+	}
+	return val, nil
+}
+
+// parseOpenAirLimit converts an altitude limit such as "FL95", "1500ft MSL", "1000 AGL"
+// or "SFC" to feet, and returns whether it is above ground level.
+func parseOpenAirLimit(s string) (int, bool, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	switch s {
+	case "SFC", "GND", "":
+		return 0, false, nil
+	case "UNL", "UNLIM", "UNLTD", "UNLIMITED":
+		return unlimitedAltitude, false, nil
+	}
+
+	if strings.HasPrefix(s, "FL") {
+		fl, err := strconv.Atoi(strings.TrimSpace(s[2:]))
+		if err != nil {
+			return 0, false, errors.Wrap(err, fmt.Sprintf("invalid flight level: %q", s))
+		}
+		return fl * 100, false, nil
+	}
+
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	if i == 0 {
+		return 0, false, fmt.Errorf("invalid altitude limit: %q", s)
+	}
+	val, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, false, errors.Wrap(err, fmt.Sprintf("invalid altitude limit: %q", s))
+	}
+
+	agl := false
+	for _, f := range strings.Fields(s[i:]) {
+		switch f {
+		case "M", "MTR":
+			val *= 3.28084
+		case "AGL", "AGND", "SFC", "GND":
+			agl = true
+		}
+	}
+
+	return int(val), agl, nil
+}
+
+// checkAirspace updates the airspace the plane is in, raising events when it enters
+// airspace of one of the event classes.
+func checkAirspace(pl *Plane) {
+	if len(airspaces) == 0 || len(pl.Locations) == 0 {
+		return
+	}
+
+	l := pl.Locations[len(pl.Locations)-1]
+	lat, lon := float64(l.Latitude), float64(l.Longitude)
+
+	var current []string
+	for _, id := range airspaceIndex.Query(lat, lon) {
+		a := airspaces[id]
+		if !a.Contains(lat, lon, pl.Altitude) {
+			continue
+		}
+
+		label := a.Label()
+		current = append(current, label)
+		if !eventClasses[a.Class] || containsString(pl.Airspace, label) {
+			continue
+		}
+
+		e := &AirspaceEvent{Icao: pl.Icao, Time: l.Time, Airspace: a.Name, Class: a.Class, CallSign: pl.CallSign, Squawk: pl.Squawk,
+			Latitude: l.Latitude, Longitude: l.Longitude, Altitude: pl.Altitude}
+		e.Incursion = pl.Squawk != "" && uncontrolledSquawks[pl.Squawk]
+		if verbose || e.Incursion {
+			fmt.Printf("Airspace: %06X (%s) entered %s squawking %q\n", e.Icao, e.CallSign, label, e.Squawk)
+		}
+		go func() {
+			err := SaveAirspaceEvent(e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error saving airspace event: %v\n", err)
+			}
+		}()
+	}
+
+	pl.Airspace = current
+}
+
+func containsString(sl []string, s string) bool {
+	for _, v := range sl {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func getAirspaceEvents(t time.Time, incursions bool) string {
+	events, err := LoadAirspaceEvents(t, incursions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading airspace events: %v\n", err)
+		return "[]"
+	}
+
+	sl := make([]string, len(events))
+	for i, e := range events {
+		sl[i] = e.ToJson()
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
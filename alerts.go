@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	AlertEnter = "enter"
+	AlertExit  = "exit"
+	// Contact was lost with the plane while it was in the state.
+	AlertLost = "lost"
+)
+
+// Emergency squawk codes and the kind of alert they raise.
+var emergencySquawks = map[string]string{
+	"7500": "hijack",
+	"7600": "radio failure",
+	"7700": "emergency",
+}
+
+type Alert struct {
+	id        int
+	Icao      uint
+	Time      time.Time
+	Kind      string
+	State     string
+	CallSign  string
+	Squawk    string
+	Latitude  float32
+	Longitude float32
+	Altitude  int
+}
+
+func (a *Alert) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", a.id))
+	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", a.Icao))
+	buf.WriteString(fmt.Sprintf("\"time\": %q, ", a.Time.String()))
+	buf.WriteString(fmt.Sprintf("\"kind\": %q, ", a.Kind))
+	buf.WriteString(fmt.Sprintf("\"state\": %q, ", a.State))
+	buf.WriteString(fmt.Sprintf("\"callsign\": %q, ", a.CallSign))
+	buf.WriteString(fmt.Sprintf("\"squawk\": %q, ", a.Squawk))
+	if a.Latitude != 0 || a.Longitude != 0 {
+		buf.WriteString(fmt.Sprintf("\"location\": \"%f,%f\", ", a.Latitude, a.Longitude))
+	}
+	buf.WriteString(units.altitude("altitude", a.Altitude))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+var (
+	// Sinks all alerts are delivered to.
+	alertSinks []Sink
+	// Current emergency kind of each plane in an emergency state.
+	alertStates = make(map[uint]string)
+	// Time an alert was last raised, keyed by icao, kind and state.
+	alertRaised = make(map[string]time.Time)
+)
+
+// initAlerts creates the alert sinks from the command line flags.
+func initAlerts() {
+	if alertWebhook != "" {
+		alertSinks = append(alertSinks, &WebhookSink{URL: alertWebhook})
+	}
+	if alertExec != "" {
+		alertSinks = append(alertSinks, &ExecSink{Command: alertExec})
+	}
+	if alertLog != "" {
+		alertSinks = append(alertSinks, &FileSink{Path: alertLog})
+	}
+}
+
+// emergencyKind returns the kind of emergency the plane is currently declaring, or
+// an empty string if there is none. An emergency squawk takes priority over the flag.
+func emergencyKind(pl *Plane) string {
+	if _, ok := emergencySquawks[pl.Squawk]; ok {
+		return pl.Squawk
+	}
+	if pl.Emergency {
+		return "emergency flag"
+	}
+	return ""
+}
+
+// checkAlerts raises an alert if the plane has entered or left an emergency state.
+func checkAlerts(pl *Plane, t time.Time) {
+	kind := emergencyKind(pl)
+	prev := alertStates[pl.Icao]
+	if kind == prev {
+		return
+	}
+
+	if kind == "" {
+		delete(alertStates, pl.Icao)
+	} else {
+		alertStates[pl.Icao] = kind
+	}
+
+	if prev != "" {
+		raiseAlert(newAlert(pl, prev, AlertExit, t))
+	}
+	if kind != "" {
+		raiseAlert(newAlert(pl, kind, AlertEnter, t))
+	}
+}
+
+func newAlert(pl *Plane, kind, state string, t time.Time) *Alert {
+	a := &Alert{Icao: pl.Icao, Time: t, Kind: kind, State: state, CallSign: pl.CallSign, Squawk: pl.Squawk, Altitude: pl.Altitude}
+	if len(pl.Locations) > 0 {
+		l := pl.Locations[len(pl.Locations)-1]
+		a.Latitude = l.Latitude
+		a.Longitude = l.Longitude
+	}
+	return a
+}
+
+// raiseAlert stores the alert and delivers it to the alert sinks. Repeats of the same
+// alert for a plane within the cooldown period are dropped.
+func raiseAlert(a *Alert) {
+	key := fmt.Sprintf("%06X|%s|%s", a.Icao, a.Kind, a.State)
+	if last, ok := alertRaised[key]; ok && a.Time.Sub(last) < alertCooldown {
+		if verbose {
+			fmt.Printf("Suppressing repeated alert: %06X %s %s\n", a.Icao, a.Kind, a.State)
+		}
+		return
+	}
+	alertRaised[key] = a.Time
+
+	fmt.Printf("ALERT: %06X (%s) %s %s\n", a.Icao, a.CallSign, a.State, alertDescription(a.Kind))
+
+	// Saved before the payload is built so that it has the id of the stored alert.
+	err := SaveAlert(a)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error saving alert: %v\n", err)
+	}
+	deliver(alertSinks, a.ToJson())
+}
+
+func alertDescription(kind string) string {
+	if desc, ok := emergencySquawks[kind]; ok {
+		return fmt.Sprintf("squawk %s (%s)", kind, desc)
+	}
+	return kind
+}
+
+// forgetAlerts drops any alert state held for a plane which is no longer active, raising
+// a lost alert if it was still in an emergency state. There is none when shutting down, as
+// the plane hasn't gone anywhere. Cooldowns which have expired are also removed.
+func forgetAlerts(pl *Plane, t time.Time) {
+	icao := pl.Icao
+	if kind, ok := alertStates[icao]; ok && !t.IsZero() {
+		raiseAlert(newAlert(pl, kind, AlertLost, pl.LastSeen))
+	}
+	delete(alertStates, icao)
+
+	prefix := fmt.Sprintf("%06X|", icao)
+	for key, last := range alertRaised {
+		if strings.HasPrefix(key, prefix) && t.Sub(last) >= alertCooldown {
+			delete(alertRaised, key)
+		}
+	}
+}
+
+func getAlerts(icao uint, t time.Time) string {
+	alerts, err := LoadAlerts(icao, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading alerts: %v\n", err)
+		return "[]"
+	}
+
+	sl := make([]string, len(alerts))
+	for i, a := range alerts {
+		sl[i] = a.ToJson()
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
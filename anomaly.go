@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	AnomalyDuplicate    = "duplicate icao"
+	AnomalyRange        = "out of range"
+	AnomalyClimb        = "impossible climb"
+	AnomalyCallsignSwap = "callsign swap"
+	AnomalyIcaoSwap     = "icao swap"
+)
+
+// Weight of each kind of anomaly in the score. Each kind counts up to three times.
+var anomalyWeights = map[string]int{
+	AnomalyDuplicate:    40,
+	AnomalyRange:        30,
+	AnomalyClimb:        20,
+	AnomalyCallsignSwap: 10,
+	AnomalyIcaoSwap:     25,
+}
+
+const (
+	// Ground speed in knots no aircraft we expect to see can fly faster than.
+	maxPlausibleSpeed = 2000
+	// Jumps shorter than this in nm are position noise rather than a second aircraft.
+	minJump = 2.0
+	// Vertical rate in feet per minute no aircraft we expect to see can climb or descend faster than.
+	maxPlausibleRate = 12000
+	// Height of the receiver antenna in feet, used for the radio horizon.
+	antennaHeight = 100
+	// Altitude above which a change of callsign is unexpected.
+	callsignSwapAltitude = 10000
+	// Time another plane with the same callsign must have been seen in to be an ICAO swap.
+	icaoSwapPeriod = time.Minute
+)
+
+type Anomaly struct {
+	id        int
+	Icao      uint
+	Time      time.Time
+	Kind      string
+	Detail    string
+	CallSign  string
+	Latitude  float32
+	Longitude float32
+	Altitude  int
+}
+
+func (a *Anomaly) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", a.id))
+	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", a.Icao))
+	buf.WriteString(fmt.Sprintf("\"time\": %q, ", a.Time.String()))
+	buf.WriteString(fmt.Sprintf("\"kind\": %q, ", a.Kind))
+	buf.WriteString(fmt.Sprintf("\"detail\": %q, ", a.Detail))
+	buf.WriteString(fmt.Sprintf("\"callsign\": %q, ", a.CallSign))
+	buf.WriteString(fmt.Sprintf("\"location\": \"%f,%f\", ", a.Latitude, a.Longitude))
+	buf.WriteString(units.altitude("altitude", a.Altitude))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+// checkAnomalies looks for positions and altitudes the plane can't have flown.
+func checkAnomalies(pl *Plane, t time.Time) {
+	if len(pl.Locations) == 0 {
+		return
+	}
+	cur := pl.Locations[len(pl.Locations)-1]
+
+	if len(pl.Locations) >= 2 {
+		prev := pl.Locations[len(pl.Locations)-2]
+		dt := math.Abs(cur.Time.Sub(prev.Time).Hours())
+		// Timestamps are to the second, so allow for a second between positions.
+		dt = math.Max(dt, 1.0/3600)
+
+		dist := distanceNm(float64(prev.Latitude), float64(prev.Longitude), float64(cur.Latitude), float64(cur.Longitude))
+		if dist > minJump && dist/dt > maxPlausibleSpeed {
+			raiseAnomaly(pl, AnomalyDuplicate, fmt.Sprintf("moved %.1fnm in %.0fs", dist, dt*3600), t)
+		}
+
+		if prev.Altitude != 0 && cur.Altitude != 0 && dt*3600 >= 2 {
+			rate := math.Abs(float64(cur.Altitude-prev.Altitude)) / (dt * 60)
+			if rate > maxPlausibleRate {
+				raiseAnomaly(pl, AnomalyClimb, fmt.Sprintf("altitude changed at %.0fft/min", rate), t)
+			}
+		}
+	}
+
+	if haveReceiver() && pl.Altitude > 0 {
+		// Radio horizon of the receiver and the aircraft, with a margin for refraction.
+		horizon := 1.23 * (math.Sqrt(float64(pl.Altitude)) + math.Sqrt(antennaHeight)) * 1.2
+		dist := distanceNm(rxLat, rxLon, float64(cur.Latitude), float64(cur.Longitude))
+		if dist > horizon {
+			raiseAnomaly(pl, AnomalyRange, fmt.Sprintf("%.0fnm from the receiver, beyond the %.0fnm horizon", dist, horizon), t)
+		}
+	}
+}
+
+// checkVerticalRate looks for a reported vertical rate no aircraft can fly.
+func checkVerticalRate(pl *Plane, t time.Time) {
+	if pl.Vertical > maxPlausibleRate || pl.Vertical < -maxPlausibleRate {
+		raiseAnomaly(pl, AnomalyClimb, fmt.Sprintf("reported %dft/min", pl.Vertical), t)
+	}
+}
+
+// checkCallsignChange looks for a callsign changing in flight, or taken over from another
+// plane which was just seen.
+func checkCallsignChange(pl *Plane, prev string, t time.Time) {
+	if prev != "" && !pl.OnGround && pl.Altitude > callsignSwapAltitude {
+		raiseAnomaly(pl, AnomalyCallsignSwap, fmt.Sprintf("changed from %s to %s at %dft", prev, pl.CallSign, pl.Altitude), t)
+	}
+
+	for icao, other := range planeCache {
+		if icao != pl.Icao && other.CallSign == pl.CallSign && t.Sub(other.LastSeen) < icaoSwapPeriod {
+			raiseAnomaly(pl, AnomalyIcaoSwap, fmt.Sprintf("callsign %s also used by %06X", pl.CallSign, icao), t)
+			return
+		}
+	}
+}
+
+// raiseAnomaly counts the anomaly against the plane and updates its score. The first of
+// each kind for the plane is stored.
+func raiseAnomaly(pl *Plane, kind, detail string, t time.Time) {
+	if pl.Anomalies == nil {
+		pl.Anomalies = make(map[string]int)
+	}
+	pl.Anomalies[kind]++
+
+	score := 0
+	for k, n := range pl.Anomalies {
+		if n > 3 {
+			n = 3
+		}
+		score += anomalyWeights[k] * n
+	}
+	if score > 100 {
+		score = 100
+	}
+	pl.Anomaly = score
+
+	if pl.Anomalies[kind] > 1 {
+		return
+	}
+
+	a := &Anomaly{Icao: pl.Icao, Time: t, Kind: kind, Detail: detail, CallSign: pl.CallSign, Altitude: pl.Altitude}
+	if len(pl.Locations) > 0 {
+		l := pl.Locations[len(pl.Locations)-1]
+		a.Latitude, a.Longitude = l.Latitude, l.Longitude
+	}
+
+	fmt.Printf("Anomaly: %06X (%s) %s: %s\n", a.Icao, a.CallSign, a.Kind, a.Detail)
+	go func() {
+		err := SaveAnomaly(a)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving anomaly: %v\n", err)
+		}
+	}()
+}
+
+// getSuspects returns the active planes with an anomaly score, highest first.
+func getSuspects() string {
+	var suspects []*Plane
+	for _, pl := range planeCache {
+		if pl.Anomaly > 0 {
+			suspects = append(suspects, pl)
+		}
+	}
+	sort.Slice(suspects, func(i, j int) bool { return suspects[i].Anomaly > suspects[j].Anomaly })
+
+	sl := make([]string, len(suspects))
+	for i, pl := range suspects {
+		var kinds []string
+		for k, n := range pl.Anomalies {
+			kinds = append(kinds, fmt.Sprintf("%q: %d", k, n))
+		}
+		sort.Strings(kinds)
+		sl[i] = fmt.Sprintf("{\"icao\": \"%06X\", \"callsign\": %q, \"score\": %d, \"anomalies\": {%s}, \"lastSeen\": %q}",
+			pl.Icao, pl.CallSign, pl.Anomaly, strings.Join(kinds, ", "), pl.LastSeen.String())
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
+
+// getAnomalies returns the stored anomalies of a plane since the time.
+func getAnomalies(icao uint, t time.Time) string {
+	anomalies, err := LoadAnomalies(icao, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading anomalies: %v\n", err)
+		return "[]"
+	}
+
+	sl := make([]string, len(anomalies))
+	for i, a := range anomalies {
+		sl[i] = a.ToJson()
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
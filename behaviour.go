@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	EventGoAround = "go-around"
+	EventHold     = "hold"
+	EventOrbit    = "orbit"
+)
+
+const (
+	// How often each plane's recent locations are scanned, and how far back.
+	behaviourScan   = time.Second * 30
+	behaviourWindow = time.Minute * 15
+	// Lowest height in feet above the airport a go-around may start its climb from.
+	goAroundHeight = 1000
+	// Descent before, and climb after, the lowest point of a go-around in feet.
+	goAroundDescent = 300
+	goAroundClimb   = 400
+	// Minimum distance in nm between locations used to work out turns, to ignore jitter.
+	turnStep = 0.2
+	// Turn rate in degrees per second below which the plane is flying straight.
+	straightRate = 1.0
+	// Share of the time a hold is flown straight, on the legs of the racetrack.
+	holdStraightShare = 0.3
+	// Largest area, as the diagonal in nm, a hold or orbits are flown in.
+	turnMaxSize = 15.0
+)
+
+// FlightEvent is unusual behaviour found in a plane's recent locations.
+type FlightEvent struct {
+	id       int
+	Icao     uint
+	Kind     string
+	Start    time.Time
+	End      time.Time
+	CallSign string
+	Min      point // south west corner of the area flown in
+	Max      point // north east corner
+	Detail   string
+}
+
+func (e *FlightEvent) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", e.id))
+	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", e.Icao))
+	buf.WriteString(fmt.Sprintf("\"kind\": %q, ", e.Kind))
+	buf.WriteString(fmt.Sprintf("\"start\": %q, ", e.Start.String()))
+	buf.WriteString(fmt.Sprintf("\"end\": %q, ", e.End.String()))
+	buf.WriteString(fmt.Sprintf("\"duration\": %.0f, ", e.End.Sub(e.Start).Seconds()))
+	buf.WriteString(fmt.Sprintf("\"callsign\": %q, ", e.CallSign))
+	buf.WriteString(fmt.Sprintf("\"bounds\": [\"%f,%f\", \"%f,%f\"], ", e.Min.Lat, e.Min.Lon, e.Max.Lat, e.Max.Lon))
+	buf.WriteString(fmt.Sprintf("\"detail\": %q", e.Detail))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+type behaviourState struct {
+	LastScan time.Time
+	// End of the last event of each kind. Later scans only look at newer locations.
+	LastEnd map[string]time.Time
+}
+
+var behaviourStates = make(map[uint]*behaviourState)
+
+// checkBehaviour scans the plane's recent locations for go-arounds, holds and orbits.
+func checkBehaviour(pl *Plane, t time.Time) {
+	st, ok := behaviourStates[pl.Icao]
+	if !ok {
+		st = &behaviourState{LastScan: t, LastEnd: make(map[string]time.Time)}
+		behaviourStates[pl.Icao] = st
+		return
+	}
+	if t.Sub(st.LastScan) < behaviourScan {
+		return
+	}
+	st.LastScan = t
+
+	// Messages are parsed concurrently so locations may be slightly out of order.
+	from := t.Add(-behaviourWindow)
+	var recent []Location
+	for _, l := range pl.Locations {
+		if l.Time.After(from) {
+			recent = append(recent, l)
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i].Time.Before(recent[j].Time) })
+
+	if e := findGoAround(pl, locationsAfter(recent, st.LastEnd[EventGoAround])); e != nil {
+		raiseFlightEvent(st, e)
+	}
+	if e := findTurns(pl, locationsAfter(recent, st.LastEnd[EventHold], st.LastEnd[EventOrbit])); e != nil {
+		raiseFlightEvent(st, e)
+	}
+}
+
+// locationsAfter returns the locations after the latest of the times.
+func locationsAfter(locs []Location, times ...time.Time) []Location {
+	var after time.Time
+	for _, t := range times {
+		if t.After(after) {
+			after = t
+		}
+	}
+
+	i := 0
+	for i < len(locs) && !locs[i].Time.After(after) {
+		i++
+	}
+	return locs[i:]
+}
+
+// bounds returns the corners of the area covering the locations.
+func bounds(locs []Location) (point, point) {
+	min := point{Lat: 90, Lon: 180}
+	max := point{Lat: -90, Lon: -180}
+	for _, l := range locs {
+		min.Lat = math.Min(min.Lat, float64(l.Latitude))
+		min.Lon = math.Min(min.Lon, float64(l.Longitude))
+		max.Lat = math.Max(max.Lat, float64(l.Latitude))
+		max.Lon = math.Max(max.Lon, float64(l.Longitude))
+	}
+	return min, max
+}
+
+// findGoAround looks for a descent to low over an airport followed by a climb, without the
+// plane arriving in between.
+func findGoAround(pl *Plane, locs []Location) *FlightEvent {
+	low := -1
+	for i, l := range locs {
+		if l.Altitude != 0 && (low < 0 || l.Altitude < locs[low].Altitude) {
+			low = i
+		}
+	}
+	if low <= 0 || low == len(locs)-1 {
+		return nil
+	}
+	lowest := locs[low]
+
+	var airport *Airport
+	for _, a := range nearbyAirports(float64(lowest.Latitude), float64(lowest.Longitude)) {
+		if lowest.Altitude-a.Elevation < goAroundHeight {
+			airport = a
+			break
+		}
+	}
+	if airport == nil {
+		return nil
+	}
+
+	// Start of the descent to the lowest point, and the end of the climb away from it.
+	start := -1
+	for i := low - 1; i >= 0; i-- {
+		if locs[i].Altitude-lowest.Altitude >= goAroundDescent {
+			start = i
+			break
+		}
+	}
+	end := -1
+	for i := low + 1; i < len(locs); i++ {
+		if locs[i].Altitude-lowest.Altitude >= goAroundClimb {
+			end = i
+			break
+		}
+	}
+	if start < 0 || end < 0 {
+		return nil
+	}
+
+	if ms, ok := movementStates[pl.Icao]; ok && ms.Last != nil && ms.Last.Kind == MovementArrival &&
+		ms.Last.Airport == airport.Ident && ms.Last.Time.After(locs[start].Time) {
+		return nil
+	}
+
+	e := &FlightEvent{Icao: pl.Icao, Kind: EventGoAround, Start: locs[start].Time, End: locs[end].Time, CallSign: pl.CallSign,
+		Detail: fmt.Sprintf("%s, lowest %dft", airport.Ident, lowest.Altitude)}
+	e.Min, e.Max = bounds(locs[start : end+1])
+	return e
+}
+
+// findTurns adds up the plane's turns. Two or more turns in the same direction in a small
+// area are orbits, or a hold if a good share of the time is spent flying straight legs.
+func findTurns(pl *Plane, locs []Location) *FlightEvent {
+	// Thin the locations out so that small position errors don't look like turns.
+	var pts []Location
+	for _, l := range locs {
+		if len(pts) > 0 {
+			p := pts[len(pts)-1]
+			if distanceNm(float64(p.Latitude), float64(p.Longitude), float64(l.Latitude), float64(l.Longitude)) < turnStep {
+				continue
+			}
+		}
+		pts = append(pts, l)
+	}
+	if len(pts) < 4 {
+		return nil
+	}
+
+	// Turn in degrees and duration of each step, between pts[i+1] and pts[i+2].
+	type step struct {
+		d, dt float64
+	}
+	steps := make([]step, 0, len(pts)-2)
+	first, last := -1, -1
+	prev := bearing(float64(pts[0].Latitude), float64(pts[0].Longitude), float64(pts[1].Latitude), float64(pts[1].Longitude))
+	for i := 2; i < len(pts); i++ {
+		b := bearing(float64(pts[i-1].Latitude), float64(pts[i-1].Longitude), float64(pts[i].Latitude), float64(pts[i].Longitude))
+		st := step{d: math.Mod(b-prev+540, 360) - 180, dt: pts[i].Time.Sub(pts[i-1].Time).Seconds()}
+		prev = b
+		steps = append(steps, st)
+
+		if st.dt > 0 && math.Abs(st.d)/st.dt >= straightRate {
+			if first < 0 {
+				first = len(steps) - 1
+			}
+			last = len(steps) - 1
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+
+	// Only the straight legs between the first and last turn count towards a hold.
+	var total, straight, turning float64
+	for _, st := range steps[first : last+1] {
+		if st.dt <= 0 {
+			continue
+		}
+		if math.Abs(st.d)/st.dt < straightRate {
+			straight += st.dt
+		} else {
+			turning += st.dt
+			total += st.d
+		}
+	}
+	if straight+turning == 0 {
+		return nil
+	}
+	turns := math.Abs(total) / 360
+
+	min, max := bounds(pts[first : last+3])
+	if distanceNm(min.Lat, min.Lon, max.Lat, max.Lon) > turnMaxSize {
+		return nil
+	}
+
+	var kind string
+	switch {
+	case straight/(straight+turning) >= holdStraightShare && turns >= 1:
+		kind = EventHold
+	case turns >= 2:
+		kind = EventOrbit
+	default:
+		return nil
+	}
+
+	dir := "right"
+	if total < 0 {
+		dir = "left"
+	}
+	return &FlightEvent{Icao: pl.Icao, Kind: kind, Start: pts[first].Time, End: pts[last+2].Time, CallSign: pl.CallSign,
+		Min: min, Max: max, Detail: fmt.Sprintf("%.1f %s turns", turns, dir)}
+}
+
+func raiseFlightEvent(st *behaviourState, e *FlightEvent) {
+	st.LastEnd[e.Kind] = e.End
+
+	if verbose {
+		fmt.Printf("Event: %06X (%s) %s %s\n", e.Icao, e.CallSign, e.Kind, e.Detail)
+	}
+	go func() {
+		err := SaveFlightEvent(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving flight event: %v\n", err)
+		}
+	}()
+}
+
+// forgetBehaviour removes the scan state of a plane which is no longer active.
+func forgetBehaviour(icao uint) {
+	delete(behaviourStates, icao)
+}
+
+func getFlightEvents(icao uint, kind string, t time.Time) string {
+	events, err := LoadFlightEvents(icao, kind, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading flight events: %v\n", err)
+		return "[]"
+	}
+
+	sl := make([]string, len(events))
+	for i, e := range events {
+		sl[i] = e.ToJson()
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
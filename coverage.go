@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// Number of bearing sectors the coverage is split into. 72 gives 5 degree sectors.
+	coverageSectors = 72
+	// Positions further than this (in nm) from the receiver are assumed to be bad decodes.
+	maxCoverageRange = 450
+	// Format used for the day key of the coverage history.
+	coverageDayFormat = "2006-01-02"
+)
+
+// Lower bound, in feet, of each altitude band coverage is kept for.
+var coverageBands = []int{0, 5000, 10000, 20000, 30000}
+
+// CoverageCell holds the furthest position observed in a single bearing sector and altitude band.
+type CoverageCell struct {
+	Range     float64
+	Latitude  float32
+	Longitude float32
+	Altitude  int
+	Time      time.Time
+	dirty     bool
+}
+
+// Coverage is the polar range map of a receiver. Cells are indexed by [band][sector].
+type Coverage struct {
+	Receiver  string
+	Latitude  float64
+	Longitude float64
+	Cells     [][]CoverageCell
+	// Maximum range seen per cell for the current day only.
+	Day        string
+	Daily      [][]float64
+	dailyDirty bool
+}
+
+// coverageSnapshot is a copy of the changed coverage values which can be saved off the main loop.
+type coverageSnapshot struct {
+	Receiver  string
+	Latitude  float64
+	Longitude float64
+	Cells     map[[2]int]CoverageCell
+	Day       string
+	Daily     [][]float64
+}
+
+// coverageDay is the summary of a single day of coverage for one altitude band.
+type coverageDay struct {
+	Day     string
+	Band    int
+	Sectors int
+	Max     float64
+	Total   float64
+}
+
+// Coverage for the receiver we are currently receiving messages from.
+var coverage *Coverage
+
+func newCoverage(receiver string, lat, lon float64) *Coverage {
+	c := &Coverage{Receiver: receiver, Latitude: lat, Longitude: lon}
+	c.Cells = make([][]CoverageCell, len(coverageBands))
+	for i := range c.Cells {
+		c.Cells[i] = make([]CoverageCell, coverageSectors)
+	}
+	c.resetDaily("")
+	return c
+}
+
+func (c *Coverage) resetDaily(day string) {
+	c.Day = day
+	c.Daily = make([][]float64, len(coverageBands))
+	for i := range c.Daily {
+		c.Daily[i] = make([]float64, coverageSectors)
+	}
+	c.dailyDirty = false
+}
+
+// coverageBand returns the index of the altitude band the altitude falls in.
+func coverageBand(alt int) int {
+	for i := len(coverageBands) - 1; i > 0; i-- {
+		if alt >= coverageBands[i] {
+			return i
+		}
+	}
+	return 0
+}
+
+// Update records the position if it is further than any previously seen in its sector and band.
+// Returns true if the coverage has changed.
+func (c *Coverage) Update(lat, lon float64, alt int, t time.Time) bool {
+	rng := distanceNm(c.Latitude, c.Longitude, lat, lon)
+	if rng > maxCoverageRange {
+		return false
+	}
+
+	sector := int(bearing(c.Latitude, c.Longitude, lat, lon)/(360.0/coverageSectors)) % coverageSectors
+	band := coverageBand(alt)
+
+	day := t.Format(coverageDayFormat)
+	if day != c.Day {
+		if c.dailyDirty {
+			// Day rolled over before the last one was saved.
+			go saveCoverageSnapshot(c.snapshot())
+		}
+		c.resetDaily(day)
+	}
+
+	changed := false
+	if rng > c.Daily[band][sector] {
+		c.Daily[band][sector] = rng
+		c.dailyDirty = true
+		changed = true
+	}
+
+	cell := &c.Cells[band][sector]
+	if rng > cell.Range {
+		cell.Range = rng
+		cell.Latitude = float32(lat)
+		cell.Longitude = float32(lon)
+		cell.Altitude = alt
+		cell.Time = t
+		cell.dirty = true
+		changed = true
+	}
+
+	return changed
+}
+
+// snapshot copies any unsaved values and marks them as saved.
+func (c *Coverage) snapshot() *coverageSnapshot {
+	s := &coverageSnapshot{Receiver: c.Receiver, Latitude: c.Latitude, Longitude: c.Longitude, Cells: make(map[[2]int]CoverageCell)}
+	for b := range c.Cells {
+		for sec := range c.Cells[b] {
+			if c.Cells[b][sec].dirty {
+				s.Cells[[2]int{b, sec}] = c.Cells[b][sec]
+				c.Cells[b][sec].dirty = false
+			}
+		}
+	}
+
+	if c.dailyDirty {
+		s.Day = c.Day
+		s.Daily = make([][]float64, len(c.Daily))
+		for b := range c.Daily {
+			s.Daily[b] = append([]float64(nil), c.Daily[b]...)
+		}
+		c.dailyDirty = false
+	}
+
+	return s
+}
+
+// loadCoverage loads the stored coverage of the receiver, if the receiver location is known.
+func loadCoverage(receiver string) error {
+	if !haveReceiver() {
+		return nil
+	}
+
+	c, err := LoadCoverage(receiver)
+	if err != nil && err != receiverNotFound {
+		coverage = newCoverage(receiver, rxLat, rxLon)
+		return err
+	}
+
+	if c == nil || c.Latitude != rxLat || c.Longitude != rxLon {
+		if c != nil {
+			fmt.Fprintf(os.Stderr, "receiver %q has moved. Resetting coverage.\n", receiver)
+		}
+		c = newCoverage(receiver, rxLat, rxLon)
+		for b := range c.Cells {
+			for sec := range c.Cells[b] {
+				c.Cells[b][sec].dirty = true
+			}
+		}
+	}
+	coverage = c
+
+	return nil
+}
+
+// updateCoverage adds the plane's latest location to the receiver coverage.
+func updateCoverage(pl *Plane) {
+	if coverage == nil || len(pl.Locations) == 0 {
+		return
+	}
+
+	l := pl.Locations[len(pl.Locations)-1]
+	coverage.Update(float64(l.Latitude), float64(l.Longitude), pl.Altitude, l.Time)
+}
+
+// saveCoverage writes any changed coverage to the database. If t is the zero time
+// the save happens before returning.
+func saveCoverage(t time.Time) {
+	if coverage == nil {
+		return
+	}
+
+	snap := coverage.snapshot()
+	if len(snap.Cells) == 0 && snap.Daily == nil {
+		return
+	}
+
+	if t != zeroTime {
+		go saveCoverageSnapshot(snap)
+	} else {
+		saveCoverageSnapshot(snap)
+	}
+}
+
+func saveCoverageSnapshot(snap *coverageSnapshot) {
+	err := SaveCoverage(snap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error saving coverage: %v\n", err)
+	}
+}
+
+// getCoverage returns the live coverage for the current receiver, or loads a stored one.
+func getCoverage(receiver string) (*Coverage, error) {
+	if coverage != nil && coverage.Receiver == receiver {
+		return coverage, nil
+	}
+
+	return LoadCoverage(receiver)
+}
+
+func coverageGeoJson(receiver string) string {
+	c, err := getCoverage(receiver)
+	if err != nil {
+		if err != receiverNotFound {
+			fmt.Fprintf(os.Stderr, "error loading coverage: %v\n", err)
+		}
+		return `{"type": "FeatureCollection", "features": []}`
+	}
+
+	features := []string{fmt.Sprintf("{\"type\": \"Feature\", \"properties\": {\"receiver\": %q}, \"geometry\": {\"type\": \"Point\", \"coordinates\": [%f, %f]}}", c.Receiver, c.Longitude, c.Latitude)}
+
+	for b, cells := range c.Cells {
+		buf := bytes.Buffer{}
+		ceiling := "null"
+		if b < len(coverageBands)-1 {
+			ceiling = units.altitudeValue(coverageBands[b+1])
+		}
+
+		var maxRange float64
+		ring := make([]string, 0, len(cells)+1)
+		for _, cell := range cells {
+			if cell.Range > maxRange {
+				maxRange = cell.Range
+			}
+			if cell.Range == 0 {
+				ring = append(ring, fmt.Sprintf("[%f, %f]", c.Longitude, c.Latitude))
+			} else {
+				ring = append(ring, fmt.Sprintf("[%f, %f]", cell.Longitude, cell.Latitude))
+			}
+		}
+		if maxRange == 0 {
+			continue
+		}
+		ring = append(ring, ring[0])
+
+		buf.WriteString("{\"type\": \"Feature\", \"properties\": {")
+		buf.WriteString(fmt.Sprintf("\"receiver\": %q, %s, %s, %s", c.Receiver, units.altitude("floor", coverageBands[b]),
+			unitField("ceiling", ceiling, units.Altitude), units.distance("maxRange", maxRange)))
+		buf.WriteString("}, \"geometry\": {\"type\": \"Polygon\", \"coordinates\": [[")
+		buf.WriteString(strings.Join(ring, ", "))
+		buf.WriteString("]]}}")
+		features = append(features, buf.String())
+	}
+
+	return "{\"type\": \"FeatureCollection\", \"features\": [" + strings.Join(features, ",\n") + "]}"
+}
+
+func coverageHistory(receiver string, t time.Time) string {
+	if coverage != nil && coverage.Receiver == receiver {
+		// Make sure today's values are included.
+		saveCoverage(zeroTime)
+	}
+
+	days, err := LoadCoverageHistory(receiver, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading coverage history: %v\n", err)
+		return "[]"
+	}
+
+	var dl []string
+	var bl []string
+	for i, d := range days {
+		var mean float64
+		if d.Sectors > 0 {
+			mean = d.Total / float64(d.Sectors)
+		}
+		bl = append(bl, fmt.Sprintf("{%s, %s, %s, \"sectors\": %d}", units.altitude("floor", coverageBands[d.Band]),
+			units.distance("maxRange", d.Max), units.distance("meanRange", mean), d.Sectors))
+
+		if i == len(days)-1 || days[i+1].Day != d.Day {
+			dl = append(dl, fmt.Sprintf("{\"day\": %q, \"bands\": [%s]}", d.Day, strings.Join(bl, ", ")))
+			bl = nil
+		}
+	}
+
+	return "[" + strings.Join(dl, ",\n") + "]"
+}
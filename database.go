@@ -6,19 +6,20 @@ import (
 	"github.com/pkg/errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 )
 
 // Locations
-// +-------------------------------------------------+
-// | RowID | ICAO (i) | Lat (f) | Lon (f) | time (i) |
-// +-------------------------------------------------+
+// +----------------------------------------------------------------+
+// | RowID | ICAO (i) | Lat (f) | Lon (f) | time (i) | Altitude (i) |
+// +----------------------------------------------------------------+
 const (
 	createLocationTable = `
-CREATE TABLE IF NOT EXISTS Locations (icao INTEGER NOT NULL, lat REAL, lon REAL, time INTEGER)
+CREATE TABLE IF NOT EXISTS Locations (icao INTEGER NOT NULL, lat REAL, lon REAL, time INTEGER, altitude INTEGER)
 `
-	queryLocations = `SELECT ROWID, lat, lon, time FROM Locations WHERE icao = ? ORDER BY time`
-	queryLocationsSince = `SELECT ROWID, lat, lon, time FROM Locations WHERE icao = ? AND time >= ? ORDER BY time`
+	queryLocations = `SELECT ROWID, lat, lon, time, IFNULL(altitude, 0) FROM Locations WHERE icao = ? ORDER BY time`
+	queryLocationsSince = `SELECT ROWID, lat, lon, time, IFNULL(altitude, 0) FROM Locations WHERE icao = ? AND time >= ? ORDER BY time`
 )
 
 // Messages
@@ -39,22 +40,294 @@ const (
 	createCallsignsTable = `
 CREATE TABLE IF NOT EXISTS Callsigns (icao INTEGER NOT NULL, callsign TEXT)
 `
+	// Matches the callsigns of an operator and flight number, ignoring leading zeros in the number.
+	queryFlights = `SELECT DISTINCT c.icao, c.callsign, IFNULL(p.lastSeen, 0) FROM Callsigns c LEFT JOIN Planes p ON p.icao = c.icao
+WHERE SUBSTR(UPPER(TRIM(c.callsign)), 1, 3) = ? AND LTRIM(SUBSTR(UPPER(TRIM(c.callsign)), 4), '0') = ? ORDER BY p.lastSeen`
 )
 
 // Planes
 // +------------------------------------------------------------------------------------------------------------------------------------------+
 // | ICAO (i) Primary Key | Altitude (i) | Track (f) | Speed (f) | Vertical (i) | LastSeen (int) | SqCh (b) | Emerg (b) | Ident (b) | Grnd (b) |
+// | FirstSeen (int) | Visits (i) | TotalTime (int) |
 // +------------------------------------------------------------------------------------------------------------------------------------------+
 const (
 	createPlaneTable = `
-CREATE TABLE IF NOT EXISTS Planes (icao INTEGER PRIMARY KEY, altitude INTEGER, track REAL, speed REAL, vertical INTEGER, lastSeen INTEGER, sqch INTEGER, emerg INTEGER, ident INTEGER, grnd INTEGER)
+CREATE TABLE IF NOT EXISTS Planes (icao INTEGER PRIMARY KEY, altitude INTEGER, track REAL, speed REAL, vertical INTEGER, lastSeen INTEGER, sqch INTEGER, emerg INTEGER, ident INTEGER, grnd INTEGER,
+	firstSeen INTEGER, visits INTEGER, totalTime INTEGER)
+`
+	queryPlane = `SELECT altitude, track, speed, vertical, lastSeen, sqch, emerg, ident, grnd, IFNULL(firstSeen, 0), IFNULL(visits, 0), IFNULL(totalTime, 0)
+FROM Planes WHERE icao = ?`
+	// The planes are joined with their reference data from the Aircraft table.
+	queryAllPlanes = `SELECT p.icao, p.altitude, p.track, p.speed, p.vertical, p.lastSeen, p.sqch, p.emerg, p.ident, p.grnd, IFNULL(p.firstSeen, 0), IFNULL(p.visits, 0), IFNULL(p.totalTime, 0),
+IFNULL(a.registration, ''), IFNULL(a.manufacturer, ''), IFNULL(a.type, ''), IFNULL(a.model, ''), IFNULL(a.operator, ''), IFNULL(a.year, '')
+FROM Planes p LEFT JOIN Aircraft a ON a.icao = p.icao ORDER BY p.lastSeen`
+	queryAllPlanesSince = `SELECT p.icao, p.altitude, p.track, p.speed, p.vertical, p.lastSeen, p.sqch, p.emerg, p.ident, p.grnd, IFNULL(p.firstSeen, 0), IFNULL(p.visits, 0), IFNULL(p.totalTime, 0),
+IFNULL(a.registration, ''), IFNULL(a.manufacturer, ''), IFNULL(a.type, ''), IFNULL(a.model, ''), IFNULL(a.operator, ''), IFNULL(a.year, '')
+FROM Planes p LEFT JOIN Aircraft a ON a.icao = p.icao WHERE p.lastSeen >= ? ORDER BY p.lastSeen`
+)
+
+// Receivers
+// +-------------------------------------------+
+// | Name (s) Primary Key | Lat (f) | Lon (f) |
+// +-------------------------------------------+
+const (
+	createReceiversTable = `
+CREATE TABLE IF NOT EXISTS Receivers (name TEXT PRIMARY KEY, lat REAL, lon REAL)
+`
+	queryReceiver = `SELECT lat, lon FROM Receivers WHERE name = ?`
+)
+
+// Coverage
+// +---------------------------------------------------------------------------------------------------+
+// | Receiver (s) | Band (i) | Sector (i) | Range (f) | Lat (f) | Lon (f) | Altitude (i) | time (i) |
+// +---------------------------------------------------------------------------------------------------+
+const (
+	createCoverageTable = `
+CREATE TABLE IF NOT EXISTS Coverage (receiver TEXT NOT NULL, band INTEGER, sector INTEGER, range REAL, lat REAL, lon REAL, altitude INTEGER, time INTEGER, PRIMARY KEY (receiver, band, sector))
+`
+	queryCoverage = `SELECT band, sector, range, lat, lon, altitude, time FROM Coverage WHERE receiver = ?`
+)
+
+// CoverageHistory
+// +--------------------------------------------------------------+
+// | Receiver (s) | Day (s) | Band (i) | Sector (i) | Range (f) |
+// +--------------------------------------------------------------+
+const (
+	createCoverageHistoryTable = `
+CREATE TABLE IF NOT EXISTS CoverageHistory (receiver TEXT NOT NULL, day TEXT, band INTEGER, sector INTEGER, range REAL, PRIMARY KEY (receiver, day, band, sector))
+`
+	queryCoverageHistory = `SELECT day, band, COUNT(*), MAX(range), SUM(range) FROM CoverageHistory WHERE receiver = ? AND day >= ? AND range > 0 GROUP BY day, band ORDER BY day, band`
+)
+
+// Alerts
+// +----------------------------------------------------------------------------------------------------------------------------------+
+// | RowID | ICAO (i) | time (i) | Kind (s) | State (s) | CallSign (s) | Squawk (s) | Lat (f) | Lon (f) | Altitude (i) |
+// +----------------------------------------------------------------------------------------------------------------------------------+
+const (
+	createAlertsTable = `
+CREATE TABLE IF NOT EXISTS Alerts (icao INTEGER NOT NULL, time INTEGER, kind TEXT, state TEXT, callsign TEXT, squawk TEXT, lat REAL, lon REAL, altitude INTEGER)
+`
+	queryAlerts = `SELECT ROWID, icao, time, kind, state, callsign, squawk, lat, lon, altitude FROM Alerts WHERE time >= ? ORDER BY time`
+	queryPlaneAlerts = `SELECT ROWID, icao, time, kind, state, callsign, squawk, lat, lon, altitude FROM Alerts WHERE icao = ? AND time >= ? ORDER BY time`
+)
+
+// FenceEvents
+// +------------------------------------------------------------------------------------------------------------+
+// | RowID | Fence (s) | ICAO (i) | time (i) | State (s) | CallSign (s) | Lat (f) | Lon (f) | Altitude (i) |
+// +------------------------------------------------------------------------------------------------------------+
+const (
+	createFenceEventsTable = `
+CREATE TABLE IF NOT EXISTS FenceEvents (fence TEXT NOT NULL, icao INTEGER NOT NULL, time INTEGER, state TEXT, callsign TEXT, lat REAL, lon REAL, altitude INTEGER)
+`
+	queryFenceEvents = `SELECT ROWID, fence, icao, time, state, callsign, lat, lon, altitude FROM FenceEvents WHERE fence = ? AND time >= ? ORDER BY time`
+)
+
+// AirspaceEvents
+// +-------------------------------------------------------------------------------------------------------------------------------------------------+
+// | RowID | ICAO (i) | time (i) | Airspace (s) | Class (s) | CallSign (s) | Squawk (s) | Lat (f) | Lon (f) | Altitude (i) | Incursion (b) |
+// +-------------------------------------------------------------------------------------------------------------------------------------------------+
+const (
+	createAirspaceEventsTable = `
+CREATE TABLE IF NOT EXISTS AirspaceEvents (icao INTEGER NOT NULL, time INTEGER, airspace TEXT, class TEXT, callsign TEXT, squawk TEXT, lat REAL, lon REAL, altitude INTEGER, incursion INTEGER)
+`
+	queryAirspaceEvents = `SELECT ROWID, icao, time, airspace, class, callsign, squawk, lat, lon, altitude, incursion FROM AirspaceEvents WHERE time >= ? AND incursion >= ? ORDER BY time`
+)
+
+// Aircraft
+// +----------------------------------------------------------------------------------------------------------------------------+
+// | ICAO (i) Primary Key | Registration (s) | Manufacturer (s) | Type (s) | Model (s) | Operator (s) | Year (s) | Source (s) |
+// +----------------------------------------------------------------------------------------------------------------------------+
+const (
+	createAircraftTable = `
+CREATE TABLE IF NOT EXISTS Aircraft (icao INTEGER PRIMARY KEY, registration TEXT, manufacturer TEXT, type TEXT, model TEXT, operator TEXT, year TEXT, source TEXT)
+`
+	queryAircraft = `SELECT registration, manufacturer, type, model, operator, year FROM Aircraft WHERE icao = ?`
+	queryAircraftByReg = `SELECT icao FROM Aircraft WHERE UPPER(REPLACE(REPLACE(registration, ' ', ''), '-', '')) = ?`
+)
+
+// Airlines
+// +-----------------------------------------------------------------+
+// | ICAO (s) Primary Key | IATA (s) | Name (s) | Callsign (s) |
+// +-----------------------------------------------------------------+
+const (
+	createAirlinesTable = `
+CREATE TABLE IF NOT EXISTS Airlines (icao TEXT PRIMARY KEY, iata TEXT, name TEXT, callsign TEXT)
+`
+	queryAirlines = `SELECT icao, iata, name, callsign FROM Airlines`
+)
+
+// Airports
+// +-----------------------------------------------------------------------------------------------+
+// | Ident (s) Primary Key | IATA (s) | Name (s) | Lat (f) | Lon (f) | Elevation (i) | Country (s) |
+// +-----------------------------------------------------------------------------------------------+
+const (
+	createAirportsTable = `
+CREATE TABLE IF NOT EXISTS Airports (ident TEXT PRIMARY KEY, iata TEXT, name TEXT, lat REAL, lon REAL, elevation INTEGER, country TEXT)
+`
+	queryAirports = `SELECT ident, iata, name, lat, lon, elevation, country FROM Airports`
+)
+
+// Routes
+// +---------------------------------------------+
+// | Callsign (s) Primary Key | Airports (s) |
+// +---------------------------------------------+
+const (
+	createRoutesTable = `
+CREATE TABLE IF NOT EXISTS Routes (callsign TEXT PRIMARY KEY, airports TEXT)
+`
+	queryRoute = `SELECT airports FROM Routes WHERE callsign = ?`
+)
+
+// Runways
+// +------------------------------------------------------------------------------------------------------------------------------------------+
+// | Airport (s) | LE Ident (s) | Length (i) | LE Lat (f) | LE Lon (f) | LE Heading (f) | HE Ident (s) | HE Lat (f) | HE Lon (f) | HE Heading (f) |
+// +------------------------------------------------------------------------------------------------------------------------------------------+
+const (
+	createRunwaysTable = `
+CREATE TABLE IF NOT EXISTS Runways (airport TEXT NOT NULL, le_ident TEXT NOT NULL, length INTEGER, le_lat REAL, le_lon REAL, le_heading REAL,
+	he_ident TEXT, he_lat REAL, he_lon REAL, he_heading REAL, PRIMARY KEY (airport, le_ident))
+`
+	queryRunways = `SELECT airport, le_ident, length, le_lat, le_lon, le_heading, he_ident, he_lat, he_lon, he_heading FROM Runways`
+)
+
+// Movements
+// +-----------------------------------------------------------------------------------------------------------------------+
+// | RowID | ICAO (i) | time (i) | Airport (s) | Runway (s) | Kind (s) | CallSign (s) | Lat (f) | Lon (f) | Altitude (i) |
+// +-----------------------------------------------------------------------------------------------------------------------+
+const (
+	createMovementsTable = `
+CREATE TABLE IF NOT EXISTS Movements (icao INTEGER NOT NULL, time INTEGER, airport TEXT NOT NULL, runway TEXT, kind TEXT, callsign TEXT, lat REAL, lon REAL, altitude INTEGER)
+`
+	queryMovements = `SELECT ROWID, icao, time, airport, runway, kind, callsign, lat, lon, altitude FROM Movements WHERE airport = ? AND time >= ? ORDER BY time DESC`
+)
+
+// RunwayConfigs
+// +---------------------------------------------------------------------------+
+// | RowID | Airport (s) | time (i) | Arrivals (s) | Departures (s) |
+// +---------------------------------------------------------------------------+
+const (
+	createRunwayConfigsTable = `
+CREATE TABLE IF NOT EXISTS RunwayConfigs (airport TEXT NOT NULL, time INTEGER, arrivals TEXT, departures TEXT)
+`
+	queryRunwayConfigs = `SELECT ROWID, airport, time, arrivals, departures FROM RunwayConfigs WHERE airport = ? AND time >= ? ORDER BY time`
+	queryLatestRunwayConfig = `SELECT ROWID, airport, time, arrivals, departures FROM RunwayConfigs WHERE airport = ? ORDER BY time DESC LIMIT 1`
+)
+
+// PhaseChanges
+// +-------------------------------------------------------------------------------------+
+// | RowID | ICAO (i) | time (i) | From (s) | To (s) | Altitude (i) | Speed (f) |
+// +-------------------------------------------------------------------------------------+
+const (
+	createPhaseChangesTable = `
+CREATE TABLE IF NOT EXISTS PhaseChanges (icao INTEGER NOT NULL, time INTEGER, from_phase TEXT, to_phase TEXT, altitude INTEGER, speed REAL)
+`
+	queryPhaseChanges = `SELECT ROWID, icao, time, from_phase, to_phase, altitude, speed FROM PhaseChanges WHERE icao = ? AND time >= ? ORDER BY time`
+)
+
+// FlightEvents
+// +------------------------------------------------------------------------------------------------------------------------------------------+
+// | RowID | ICAO (i) | Kind (s) | Start (i) | End (i) | CallSign (s) | Min Lat (f) | Min Lon (f) | Max Lat (f) | Max Lon (f) | Detail (s) |
+// +------------------------------------------------------------------------------------------------------------------------------------------+
+const (
+	createFlightEventsTable = `
+CREATE TABLE IF NOT EXISTS FlightEvents (icao INTEGER NOT NULL, kind TEXT, start_time INTEGER, end_time INTEGER, callsign TEXT, min_lat REAL, min_lon REAL, max_lat REAL, max_lon REAL, detail TEXT)
+`
+	queryFlightEvents = `SELECT ROWID, icao, kind, start_time, end_time, callsign, min_lat, min_lon, max_lat, max_lon, detail FROM FlightEvents
+WHERE (? = 0 OR icao = ?) AND (? = '' OR kind = ?) AND start_time >= ? ORDER BY start_time`
+)
+
+// Encounters
+// +---------------------------------------------------------------------------------------------------------------------------------------+
+// | RowID | ICAO 1 (i) | ICAO 2 (i) | CallSign 1 (s) | CallSign 2 (s) | Start (i) | End (i) | CPA time (i) | Distance (f) | Vertical (i) |
+// | Lat 1 (f) | Lon 1 (f) | Altitude 1 (i) | Lat 2 (f) | Lon 2 (f) | Altitude 2 (i) |
+// +---------------------------------------------------------------------------------------------------------------------------------------+
+const (
+	createEncountersTable = `
+CREATE TABLE IF NOT EXISTS Encounters (icao1 INTEGER NOT NULL, icao2 INTEGER NOT NULL, callsign1 TEXT, callsign2 TEXT, start_time INTEGER, end_time INTEGER,
+	cpa_time INTEGER, distance REAL, vertical INTEGER, lat1 REAL, lon1 REAL, altitude1 INTEGER, lat2 REAL, lon2 REAL, altitude2 INTEGER)
+`
+	queryEncounters = `SELECT ROWID, icao1, icao2, callsign1, callsign2, start_time, end_time, cpa_time, distance, vertical, lat1, lon1, altitude1, lat2, lon2, altitude2
+FROM Encounters WHERE start_time >= ? ORDER BY start_time`
+)
+
+// Anomalies
+// +--------------------------------------------------------------------------------------------------------------+
+// | RowID | ICAO (i) | Time (i) | Kind (s) | Detail (s) | CallSign (s) | Latitude (f) | Longitude (f) | Altitude (i) |
+// +--------------------------------------------------------------------------------------------------------------+
+const (
+	createAnomaliesTable = `
+CREATE TABLE IF NOT EXISTS Anomalies (icao INTEGER NOT NULL, time INTEGER, kind TEXT, detail TEXT, callsign TEXT, lat REAL, lon REAL, altitude INTEGER)
+`
+	queryAnomalies = `SELECT ROWID, icao, time, kind, detail, callsign, lat, lon, altitude FROM Anomalies WHERE icao = ? AND time >= ? ORDER BY time`
+)
+
+// Watchlist
+// +----------------------------------------------------------+
+// | ID (i) | Kind (s) | Value (s) | Label (s) | Note (s) |
+// +----------------------------------------------------------+
+const (
+	createWatchlistTable = `
+CREATE TABLE IF NOT EXISTS Watchlist (id INTEGER PRIMARY KEY, kind TEXT NOT NULL, value TEXT NOT NULL, label TEXT, note TEXT)
+`
+	queryWatchlist = `SELECT id, kind, value, label, note FROM Watchlist ORDER BY id`
+)
+
+// Rules
+// +------------------------------------------------------------------------------------+
+// | ID (i) | Name (s) | Expr (s) | Cooldown (i) | Sinks (s) | Dry Run (i) |
+// +------------------------------------------------------------------------------------+
+const (
+	createRulesTable = `
+CREATE TABLE IF NOT EXISTS Rules (id INTEGER PRIMARY KEY, name TEXT, expr TEXT NOT NULL, cooldown INTEGER, sinks TEXT, dry_run INTEGER)
+`
+	queryRules = `SELECT id, name, expr, cooldown, sinks, dry_run FROM Rules ORDER BY id`
+)
+
+// Logbook
+// +---------------------------------------------------------------------------------------------------------------------+
+// | RowID | ICAO (i) | First Seen (i) | Last Seen (i) | CallSigns (s) | Squawks (s) | Min Alt (i) | Max Alt (i) |
+// | Max Speed (f) | Distance (f) | Closest (f) | Messages (i) | Positions (i) |
+// +---------------------------------------------------------------------------------------------------------------------+
+const (
+	createLogbookTable = `
+CREATE TABLE IF NOT EXISTS Logbook (icao INTEGER NOT NULL, first_seen INTEGER, last_seen INTEGER, callsigns TEXT, squawks TEXT, min_altitude INTEGER,
+	max_altitude INTEGER, max_speed REAL, distance REAL, closest REAL, messages INTEGER, positions INTEGER)
+`
+	queryLogbook = `SELECT ROWID, icao, first_seen, last_seen, callsigns, squawks, min_altitude, max_altitude, max_speed, distance, closest, messages, positions
+FROM Logbook WHERE (? = 0 OR icao = ?) AND last_seen >= ? ORDER BY first_seen`
+)
+
+// Sightings
+// +---------------------------------------------------------+
+// | RowID | ICAO (i) | Time (i) | Kind (s) | Previous (i) |
+// +---------------------------------------------------------+
+const (
+	createSightingsTable = `
+CREATE TABLE IF NOT EXISTS Sightings (icao INTEGER NOT NULL, time INTEGER, kind TEXT, previous INTEGER)
+`
+	querySightings = `SELECT ROWID, icao, time, kind, previous FROM Sightings WHERE time >= ? ORDER BY time`
+)
+
+// Overflights
+// +----------------------------------------------------------------------------------------------------------------------------------+
+// | RowID | Point (s) | ICAO (i) | Callsign (s) | Type (s) | Start Time (i) | End Time (i) | Time (i) | Distance (f) | Altitude (i) |
+// +----------------------------------------------------------------------------------------------------------------------------------+
+const (
+	createOverflightsTable = `
+CREATE TABLE IF NOT EXISTS Overflights (point TEXT NOT NULL, icao INTEGER NOT NULL, callsign TEXT, type TEXT, start_time INTEGER,
+  end_time INTEGER, time INTEGER, distance REAL, altitude INTEGER)
+`
+	queryOverflights = `
+SELECT ROWID, point, icao, callsign, type, start_time, end_time, time, distance, altitude FROM Overflights
+WHERE point = ? AND time >= ? AND (? = 0 OR time < ?) ORDER BY time
 `
-	queryPlane = `SELECT altitude, track, speed, vertical, lastSeen, sqch, emerg, ident, grnd FROM Planes WHERE icao = ?`
-	queryAllPlanes = `SELECT icao, altitude, track, speed, vertical, lastSeen, sqch, emerg, ident, grnd FROM Planes ORDER BY lastSeen`
-	queryAllPlanesSince = `SELECT icao, altitude, track, speed, vertical, lastSeen, sqch, emerg, ident, grnd FROM Planes WHERE lastSeen >= ? ORDER BY lastSeen`
 )
 
 var planeNotFound = errors.New("plane not found")
+var receiverNotFound = errors.New("receiver not found")
+var aircraftNotFound = errors.New("aircraft not found")
+var routeNotFound = errors.New("route not found")
+var runwayConfigNotFound = errors.New("runway configuration not found")
 
 var db *sql.DB
 
@@ -81,16 +354,157 @@ func initDB() error {
 	if err != nil {
 		return errors.Wrap(err, "unable to create Locations table.")
 	}
+	err = addColumn("Locations", "altitude", "INTEGER")
+	if err != nil {
+		return err
+	}
+	for _, c := range []string{"firstSeen", "visits", "totalTime"} {
+		err = addColumn("Planes", c, "INTEGER")
+		if err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(createReceiversTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Receivers table.")
+	}
+	_, err = db.Exec(createCoverageTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Coverage table.")
+	}
+	_, err = db.Exec(createCoverageHistoryTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create CoverageHistory table.")
+	}
+	_, err = db.Exec(createAlertsTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Alerts table.")
+	}
+	_, err = db.Exec(createFenceEventsTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create FenceEvents table.")
+	}
+	_, err = db.Exec(createAirspaceEventsTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create AirspaceEvents table.")
+	}
+	_, err = db.Exec(createAircraftTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Aircraft table.")
+	}
+	_, err = db.Exec(createAirlinesTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Airlines table.")
+	}
+	_, err = db.Exec(createAirportsTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Airports table.")
+	}
+	_, err = db.Exec(createRoutesTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Routes table.")
+	}
+	_, err = db.Exec(createRunwaysTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Runways table.")
+	}
+	_, err = db.Exec(createMovementsTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Movements table.")
+	}
+	_, err = db.Exec(createRunwayConfigsTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create RunwayConfigs table.")
+	}
+	_, err = db.Exec(createPhaseChangesTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create PhaseChanges table.")
+	}
+	_, err = db.Exec(createFlightEventsTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create FlightEvents table.")
+	}
+	_, err = db.Exec(createEncountersTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Encounters table.")
+	}
+	_, err = db.Exec(createAnomaliesTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Anomalies table.")
+	}
+	_, err = db.Exec(createWatchlistTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Watchlist table.")
+	}
+	_, err = db.Exec(createRulesTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Rules table.")
+	}
+	_, err = db.Exec(createLogbookTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Logbook table.")
+	}
+	_, err = db.Exec(createSightingsTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Sightings table.")
+	}
+	_, err = db.Exec(createOverflightsTable)
+	if err != nil {
+		return errors.Wrap(err, "unable to create Overflights table.")
+	}
+
+	return nil
+}
+
+// addColumn adds a column to a table created by an older version, if it is missing.
+func addColumn(table, column, def string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read columns of %s table.", table))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		err = rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to read columns of %s table.", table))
+		}
+		if strings.EqualFold(name, column) {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read columns of %s table.", table))
+	}
+	rows.Close()
 
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to add %s to %s table.", column, table))
+	}
 	return nil
 }
 
+// sinceNano returns the time in nanoseconds for use in "since" queries. The zero time
+// returns 0 so that all rows are matched.
+func sinceNano(t time.Time) int64 {
+	if t == zeroTime {
+		return 0
+	}
+	return t.UnixNano()
+}
+
 func closeDB() error {
 	err := db.Close()
 
 	return err
 }
 
+// LoadAll returns the stored planes last seen since the time, with their reference data
+// from the Aircraft table if known.
 func LoadAll(t time.Time) ([]*Plane, error) {
 	tx, err := db.Begin()
 	if err != nil {
@@ -113,14 +527,16 @@ func LoadAll(t time.Time) ([]*Plane, error) {
 
 	for rows.Next() {
 		p := new(Plane)
-		var tt int64
+		var tt, first, total int64
 		var icao int
-		err = rows.Scan(&icao, &p.Altitude, &p.Track, &p.Speed, &p.Vertical, &tt, &p.SquawkCh, &p.Emergency, &p.Ident, &p.OnGround)
+		err = rows.Scan(&icao, &p.Altitude, &p.Track, &p.Speed, &p.Vertical, &tt, &p.SquawkCh, &p.Emergency, &p.Ident, &p.OnGround,
+			&first, &p.Visits, &total, &p.Info.Registration, &p.Info.Manufacturer, &p.Info.Type, &p.Info.Model, &p.Info.Operator, &p.Info.Year)
 		if err != nil {
 			return nil, errors.Wrap(err, "error loading values of planes.")
 		}
 		p.Icao = uint(icao)
 		p.LastSeen = time.Unix(0, tt)
+		setVisits(p, first, total)
 		err = LoadCallsigns(p, tx)
 		if err != nil {
 			return nil, err
@@ -132,7 +548,7 @@ func LoadAll(t time.Time) ([]*Plane, error) {
 }
 
 func LoadPlane(icao uint) (*Plane, error) {
-	var tt int64
+	var tt, first, total int64
 	p := &Plane{Icao: icao}
 
 	tx, err := db.Begin()
@@ -141,7 +557,8 @@ func LoadPlane(icao uint) (*Plane, error) {
 	}
 	defer tx.Commit()
 
-	err = tx.QueryRow(queryPlane, int(icao)).Scan(&p.Altitude, &p.Track, &p.Speed, &p.Vertical, &tt, &p.SquawkCh, &p.Emergency, &p.Ident, &p.OnGround)
+	err = tx.QueryRow(queryPlane, int(icao)).Scan(&p.Altitude, &p.Track, &p.Speed, &p.Vertical, &tt, &p.SquawkCh, &p.Emergency, &p.Ident, &p.OnGround,
+		&first, &p.Visits, &total)
 	if err == sql.ErrNoRows {
 		fmt.Printf("Unable to find plane: %06X in the db.\n", icao)
 		return p, planeNotFound
@@ -150,6 +567,7 @@ func LoadPlane(icao uint) (*Plane, error) {
 	}
 
 	p.LastSeen = time.Unix(0, tt)
+	setVisits(p, first, total)
 
 	fmt.Println("Found plane in DB. Loading other values")
 	if err != nil {
@@ -163,6 +581,15 @@ func LoadPlane(icao uint) (*Plane, error) {
 	return p, nil
 }
 
+// setVisits sets the first seen time and total time of a loaded plane. Planes saved before
+// they were recorded have zeros.
+func setVisits(p *Plane, first, total int64) {
+	if first != 0 {
+		p.FirstSeen = time.Unix(0, first)
+	}
+	p.TotalTime = time.Duration(total)
+}
+
 func LoadCallsigns(p *Plane, tx *sql.Tx) error {
 	rows, err := tx.Query("SELECT callsign FROM Callsigns WHERE icao = ?", int(p.Icao))
 	if err != nil {
@@ -207,7 +634,7 @@ func LoadLocations(icao uint, t time.Time) ([]Location, error) {
 	for rows.Next() {
 		var l Location
 		var tt int64
-		err = rows.Scan(&l.id, &l.Latitude, &l.Longitude, &tt)
+		err = rows.Scan(&l.id, &l.Latitude, &l.Longitude, &tt, &l.Altitude)
 		if err != nil {
 			return locs, errors.Wrap(err, "unable to load values from Locations table")
 		}
@@ -229,8 +656,8 @@ func SavePlanes(planes []*Plane) error {
 	}
 
 	//icao, altitude, track, speed, vertical, lastSeen, sqch, emerg, ident, grnd
-	plSt, err := tx.Prepare(`INSERT OR REPLACE INTO Planes(icao, altitude, track, speed, vertical, lastSeen, sqch, emerg, ident, grnd)
-VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	plSt, err := tx.Prepare(`INSERT OR REPLACE INTO Planes(icao, altitude, track, speed, vertical, lastSeen, sqch, emerg, ident, grnd, firstSeen, visits, totalTime)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
@@ -238,7 +665,7 @@ VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
-	lcSt, err := tx.Prepare(`INSERT INTO Locations(icao, lat, lon, time) VALUES(?, ?, ?, ?)`)
+	lcSt, err := tx.Prepare(`INSERT INTO Locations(icao, lat, lon, time, altitude) VALUES(?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
@@ -254,7 +681,8 @@ VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 		if verbose {
 			fmt.Printf("Saving Plane: %06X\n", pl.Icao)
 		}
-		_, err = plSt.Exec(int(pl.Icao), pl.Altitude, pl.Track, pl.Speed, pl.Vertical, pl.LastSeen.UnixNano(), pl.SquawkCh, pl.Emergency, pl.Ident, pl.OnGround)
+		_, err = plSt.Exec(int(pl.Icao), pl.Altitude, pl.Track, pl.Speed, pl.Vertical, pl.LastSeen.UnixNano(), pl.SquawkCh, pl.Emergency, pl.Ident, pl.OnGround,
+			sinceNano(pl.FirstSeen), pl.Visits, int64(totalTime(pl)))
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error writing plane: %#v", err)
 		}
@@ -269,7 +697,7 @@ VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 		}
 
 		for _, lc := range pl.Locations {
-			_, err = lcSt.Exec(int(pl.Icao), lc.Latitude, lc.Longitude, lc.Time.UnixNano())
+			_, err = lcSt.Exec(int(pl.Icao), lc.Latitude, lc.Longitude, lc.Time.UnixNano(), lc.Altitude)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "error writing location: %#v", err)
 			}
@@ -306,4 +734,1158 @@ VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 
 	err = tx.Commit()
 	return err
-}
\ No newline at end of file
+}
+
+func LoadCoverage(receiver string) (*Coverage, error) {
+	var lat, lon float64
+	err := db.QueryRow(queryReceiver, receiver).Scan(&lat, &lon)
+	if err == sql.ErrNoRows {
+		return nil, receiverNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to load receiver %q", receiver))
+	}
+
+	c := newCoverage(receiver, lat, lon)
+
+	rows, err := db.Query(queryCoverage, receiver)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load coverage")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var band, sector int
+		var tt int64
+		var cell CoverageCell
+		err = rows.Scan(&band, &sector, &cell.Range, &cell.Latitude, &cell.Longitude, &cell.Altitude, &tt)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Coverage table")
+		}
+		if band < 0 || band >= len(c.Cells) || sector < 0 || sector >= coverageSectors {
+			continue
+		}
+		cell.Time = time.Unix(0, tt)
+		c.Cells[band][sector] = cell
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Coverage rows")
+	}
+
+	return c, nil
+}
+
+func LoadCoverageHistory(receiver string, t time.Time) ([]coverageDay, error) {
+	var since string
+	if t != zeroTime {
+		since = t.Format(coverageDayFormat)
+	}
+
+	rows, err := db.Query(queryCoverageHistory, receiver, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load coverage history")
+	}
+	defer rows.Close()
+
+	var days []coverageDay
+	for rows.Next() {
+		var d coverageDay
+		err = rows.Scan(&d.Day, &d.Band, &d.Sectors, &d.Max, &d.Total)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from CoverageHistory table")
+		}
+		if d.Band < 0 || d.Band >= len(coverageBands) {
+			continue
+		}
+		days = append(days, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over CoverageHistory rows")
+	}
+
+	return days, nil
+}
+
+func SaveCoverage(snap *coverageSnapshot) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO Receivers(name, lat, lon) VALUES(?, ?, ?)`, snap.Receiver, snap.Latitude, snap.Longitude)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "unable to save receiver")
+	}
+
+	cvSt, err := tx.Prepare(`INSERT OR REPLACE INTO Coverage(receiver, band, sector, range, lat, lon, altitude, time) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for key, cell := range snap.Cells {
+		_, err = cvSt.Exec(snap.Receiver, key[0], key[1], cell.Range, cell.Latitude, cell.Longitude, cell.Altitude, cell.Time.UnixNano())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing coverage: %#v\n", err)
+		}
+	}
+	err = cvSt.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error closing coverage statement: %#v\n", err)
+	}
+
+	if snap.Daily != nil {
+		hsSt, err := tx.Prepare(`INSERT INTO CoverageHistory(receiver, day, band, sector, range) VALUES(?, ?, ?, ?, ?)
+ON CONFLICT(receiver, day, band, sector) DO UPDATE SET range = MAX(range, excluded.range)`)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for band, sectors := range snap.Daily {
+			for sector, rng := range sectors {
+				if rng == 0 {
+					continue
+				}
+				_, err = hsSt.Exec(snap.Receiver, snap.Day, band, sector, rng)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error writing coverage history: %#v\n", err)
+				}
+			}
+		}
+		err = hsSt.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error closing coverage history statement: %#v\n", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func SaveAlert(a *Alert) error {
+	res, err := db.Exec(`INSERT INTO Alerts(icao, time, kind, state, callsign, squawk, lat, lon, altitude) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		int(a.Icao), a.Time.UnixNano(), a.Kind, a.State, a.CallSign, a.Squawk, a.Latitude, a.Longitude, a.Altitude)
+	if err != nil {
+		return errors.Wrap(err, "unable to write alert")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		a.id = int(id)
+	}
+	return nil
+}
+
+func LoadAlerts(icao uint, t time.Time) ([]*Alert, error) {
+	var rows *sql.Rows
+	var err error
+
+	if icao == 0 {
+		rows, err = db.Query(queryAlerts, sinceNano(t))
+	} else {
+		rows, err = db.Query(queryPlaneAlerts, int(icao), sinceNano(t))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load alerts")
+	}
+	defer rows.Close()
+
+	var alerts []*Alert
+	for rows.Next() {
+		a := new(Alert)
+		var tt int64
+		var ic int
+		err = rows.Scan(&a.id, &ic, &tt, &a.Kind, &a.State, &a.CallSign, &a.Squawk, &a.Latitude, &a.Longitude, &a.Altitude)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Alerts table")
+		}
+		a.Icao = uint(ic)
+		a.Time = time.Unix(0, tt)
+		alerts = append(alerts, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Alert rows")
+	}
+
+	return alerts, nil
+}
+
+func SaveFenceEvent(e *FenceEvent) error {
+	res, err := db.Exec(`INSERT INTO FenceEvents(fence, icao, time, state, callsign, lat, lon, altitude) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Fence, int(e.Icao), e.Time.UnixNano(), e.State, e.CallSign, e.Latitude, e.Longitude, e.Altitude)
+	if err != nil {
+		return errors.Wrap(err, "unable to write geofence event")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		e.id = int(id)
+	}
+	return nil
+}
+
+func LoadFenceEvents(fence string, t time.Time) ([]*FenceEvent, error) {
+	rows, err := db.Query(queryFenceEvents, fence, sinceNano(t))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load geofence events")
+	}
+	defer rows.Close()
+
+	var events []*FenceEvent
+	for rows.Next() {
+		e := new(FenceEvent)
+		var tt int64
+		var ic int
+		err = rows.Scan(&e.id, &e.Fence, &ic, &tt, &e.State, &e.CallSign, &e.Latitude, &e.Longitude, &e.Altitude)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from FenceEvents table")
+		}
+		e.Icao = uint(ic)
+		e.Time = time.Unix(0, tt)
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over FenceEvent rows")
+	}
+
+	return events, nil
+}
+
+func SaveAirspaceEvent(e *AirspaceEvent) error {
+	res, err := db.Exec(`INSERT INTO AirspaceEvents(icao, time, airspace, class, callsign, squawk, lat, lon, altitude, incursion) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		int(e.Icao), e.Time.UnixNano(), e.Airspace, e.Class, e.CallSign, e.Squawk, e.Latitude, e.Longitude, e.Altitude, e.Incursion)
+	if err != nil {
+		return errors.Wrap(err, "unable to write airspace event")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		e.id = int(id)
+	}
+	return nil
+}
+
+func LoadAirspaceEvents(t time.Time, incursions bool) ([]*AirspaceEvent, error) {
+	rows, err := db.Query(queryAirspaceEvents, sinceNano(t), incursions)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load airspace events")
+	}
+	defer rows.Close()
+
+	var events []*AirspaceEvent
+	for rows.Next() {
+		e := new(AirspaceEvent)
+		var tt int64
+		var ic int
+		err = rows.Scan(&e.id, &ic, &tt, &e.Airspace, &e.Class, &e.CallSign, &e.Squawk, &e.Latitude, &e.Longitude, &e.Altitude, &e.Incursion)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from AirspaceEvents table")
+		}
+		e.Icao = uint(ic)
+		e.Time = time.Unix(0, tt)
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over AirspaceEvent rows")
+	}
+
+	return events, nil
+}
+
+func LoadAircraftInfo(icao uint) (*AircraftInfo, error) {
+	info := new(AircraftInfo)
+	err := db.QueryRow(queryAircraft, int(icao)).Scan(&info.Registration, &info.Manufacturer, &info.Type, &info.Model, &info.Operator, &info.Year)
+	if err == sql.ErrNoRows {
+		return nil, aircraftNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to load aircraft %06X", icao))
+	}
+
+	return info, nil
+}
+
+// SaveAircraft replaces the aircraft imported from the source with the records.
+func SaveAircraft(recs []aircraftRecord, source string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM Aircraft WHERE source = ?`, source)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("unable to clear aircraft from %s", source))
+	}
+
+	acSt, err := tx.Prepare(`INSERT OR REPLACE INTO Aircraft(icao, registration, manufacturer, type, model, operator, year, source) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, r := range recs {
+		_, err = acSt.Exec(int(r.Icao), r.Info.Registration, r.Info.Manufacturer, r.Info.Type, r.Info.Model, r.Info.Operator, r.Info.Year, source)
+		if err != nil {
+			acSt.Close()
+			tx.Rollback()
+			return errors.Wrap(err, fmt.Sprintf("unable to write aircraft %06X", r.Icao))
+		}
+	}
+
+	err = acSt.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error closing aircraft statement: %#v\n", err)
+	}
+
+	return tx.Commit()
+}
+
+func LoadIcaoByRegistration(reg string) (uint, error) {
+	var icao int
+	err := db.QueryRow(queryAircraftByReg, strings.Replace(reg, "-", "", -1)).Scan(&icao)
+	if err == sql.ErrNoRows {
+		return 0, aircraftNotFound
+	} else if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("unable to find registration %q", reg))
+	}
+
+	return uint(icao), nil
+}
+
+// LoadFlights returns the stored callsigns which are any of the callsigns, given as the
+// operator and flight number without leading zeros, such as UAL123.
+func LoadFlights(callsigns []string) ([]flightRecord, error) {
+	var flights []flightRecord
+	done := make(map[string]bool)
+
+	for _, cs := range callsigns {
+		if len(cs) < 4 || done[cs] {
+			continue
+		}
+		done[cs] = true
+
+		rows, err := db.Query(queryFlights, cs[:3], cs[3:])
+		if err != nil {
+			return flights, errors.Wrap(err, "unable to search callsigns")
+		}
+
+		for rows.Next() {
+			var f flightRecord
+			var icao int
+			var tt int64
+			err = rows.Scan(&icao, &f.CallSign, &tt)
+			if err != nil {
+				rows.Close()
+				return flights, errors.Wrap(err, "unable to load values from Callsigns table")
+			}
+			f.Icao = uint(icao)
+			f.LastSeen = time.Unix(0, tt)
+			flights = append(flights, f)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return flights, errors.Wrap(err, "error iterating over Callsign rows")
+		}
+	}
+
+	return flights, nil
+}
+
+func LoadAirlines() ([]*Airline, error) {
+	rows, err := db.Query(queryAirlines)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load airlines")
+	}
+	defer rows.Close()
+
+	var al []*Airline
+	for rows.Next() {
+		a := new(Airline)
+		err = rows.Scan(&a.Icao, &a.Iata, &a.Name, &a.Callsign)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Airlines table")
+		}
+		al = append(al, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Airline rows")
+	}
+
+	return al, nil
+}
+
+func SaveAirlines(al []*Airline) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	alSt, err := tx.Prepare(`INSERT OR REPLACE INTO Airlines(icao, iata, name, callsign) VALUES(?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, a := range al {
+		_, err = alSt.Exec(a.Icao, a.Iata, a.Name, a.Callsign)
+		if err != nil {
+			alSt.Close()
+			tx.Rollback()
+			return errors.Wrap(err, fmt.Sprintf("unable to write airline %q", a.Icao))
+		}
+	}
+
+	err = alSt.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error closing airlines statement: %#v\n", err)
+	}
+
+	return tx.Commit()
+}
+
+func LoadAirports() ([]*Airport, error) {
+	rows, err := db.Query(queryAirports)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load airports")
+	}
+	defer rows.Close()
+
+	var al []*Airport
+	for rows.Next() {
+		a := new(Airport)
+		err = rows.Scan(&a.Ident, &a.Iata, &a.Name, &a.Latitude, &a.Longitude, &a.Elevation, &a.Country)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Airports table")
+		}
+		al = append(al, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Airport rows")
+	}
+
+	return al, nil
+}
+
+func SaveAirports(al []*Airport) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	apSt, err := tx.Prepare(`INSERT OR REPLACE INTO Airports(ident, iata, name, lat, lon, elevation, country) VALUES(?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, a := range al {
+		_, err = apSt.Exec(a.Ident, a.Iata, a.Name, a.Latitude, a.Longitude, a.Elevation, a.Country)
+		if err != nil {
+			apSt.Close()
+			tx.Rollback()
+			return errors.Wrap(err, fmt.Sprintf("unable to write airport %q", a.Ident))
+		}
+	}
+
+	err = apSt.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error closing airports statement: %#v\n", err)
+	}
+
+	return tx.Commit()
+}
+
+func LoadRoute(callsign string) (*Route, error) {
+	var airports string
+	err := db.QueryRow(queryRoute, callsign).Scan(&airports)
+	if err == sql.ErrNoRows {
+		return nil, routeNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to load route %q", callsign))
+	}
+
+	return &Route{CallSign: callsign, Airports: strings.Split(airports, "-")}, nil
+}
+
+// LoadRoutes returns the stored routes of the callsigns, keyed by callsign.
+func LoadRoutes(callsigns []string) (map[string]*Route, error) {
+	routes := make(map[string]*Route)
+	// Query in batches to stay within the limit on the number of parameters.
+	for len(callsigns) > 0 {
+		n := len(callsigns)
+		if n > 500 {
+			n = 500
+		}
+		args := make([]interface{}, n)
+		for i, cs := range callsigns[:n] {
+			args[i] = cs
+		}
+		callsigns = callsigns[n:]
+
+		rows, err := db.Query(`SELECT callsign, airports FROM Routes WHERE callsign IN (?`+strings.Repeat(", ?", n-1)+`)`, args...)
+		if err != nil {
+			return routes, errors.Wrap(err, "unable to load routes")
+		}
+		for rows.Next() {
+			var cs, airports string
+			err = rows.Scan(&cs, &airports)
+			if err != nil {
+				rows.Close()
+				return routes, errors.Wrap(err, "error reading values from row in Routes table")
+			}
+			routes[cs] = &Route{CallSign: cs, Airports: strings.Split(airports, "-")}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return routes, errors.Wrap(err, "error iterating over Routes rows")
+		}
+	}
+
+	return routes, nil
+}
+
+func SaveRoutes(routes []*Route) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	rtSt, err := tx.Prepare(`INSERT OR REPLACE INTO Routes(callsign, airports) VALUES(?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, r := range routes {
+		_, err = rtSt.Exec(r.CallSign, strings.Join(r.Airports, "-"))
+		if err != nil {
+			rtSt.Close()
+			tx.Rollback()
+			return errors.Wrap(err, fmt.Sprintf("unable to write route %q", r.CallSign))
+		}
+	}
+
+	err = rtSt.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error closing routes statement: %#v\n", err)
+	}
+
+	return tx.Commit()
+}
+
+// LoadRunways returns the runways of each airport.
+func LoadRunways() (map[string][]*Runway, error) {
+	rows, err := db.Query(queryRunways)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load runways")
+	}
+	defer rows.Close()
+
+	rl := make(map[string][]*Runway)
+	for rows.Next() {
+		r := new(Runway)
+		le, he := &r.Ends[0], &r.Ends[1]
+		err = rows.Scan(&r.Airport, &le.Ident, &r.Length, &le.Latitude, &le.Longitude, &le.Heading, &he.Ident, &he.Latitude, &he.Longitude, &he.Heading)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Runways table")
+		}
+		rl[r.Airport] = append(rl[r.Airport], r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Runway rows")
+	}
+
+	return rl, nil
+}
+
+func SaveRunways(rl []*Runway) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	rwSt, err := tx.Prepare(`INSERT OR REPLACE INTO Runways(airport, le_ident, length, le_lat, le_lon, le_heading, he_ident, he_lat, he_lon, he_heading) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, r := range rl {
+		le, he := r.Ends[0], r.Ends[1]
+		_, err = rwSt.Exec(r.Airport, le.Ident, r.Length, le.Latitude, le.Longitude, le.Heading, he.Ident, he.Latitude, he.Longitude, he.Heading)
+		if err != nil {
+			rwSt.Close()
+			tx.Rollback()
+			return errors.Wrap(err, fmt.Sprintf("unable to write runway %s %s", r.Airport, r.Ident()))
+		}
+	}
+
+	err = rwSt.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error closing runways statement: %#v\n", err)
+	}
+
+	return tx.Commit()
+}
+
+func SaveMovement(m *Movement) error {
+	res, err := db.Exec(`INSERT INTO Movements(icao, time, airport, runway, kind, callsign, lat, lon, altitude) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		int(m.Icao), m.Time.UnixNano(), m.Airport, m.Runway, m.Kind, m.CallSign, m.Latitude, m.Longitude, m.Altitude)
+	if err != nil {
+		return errors.Wrap(err, "unable to write movement")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		m.id = int(id)
+	}
+	return nil
+}
+
+// LoadMovements returns the movements at the airport since the time, newest first.
+func LoadMovements(airport string, t time.Time) ([]*Movement, error) {
+	rows, err := db.Query(queryMovements, airport, sinceNano(t))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load movements")
+	}
+	defer rows.Close()
+
+	var movements []*Movement
+	for rows.Next() {
+		m := new(Movement)
+		var tt int64
+		var ic int
+		err = rows.Scan(&m.id, &ic, &tt, &m.Airport, &m.Runway, &m.Kind, &m.CallSign, &m.Latitude, &m.Longitude, &m.Altitude)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Movements table")
+		}
+		m.Icao = uint(ic)
+		m.Time = time.Unix(0, tt)
+		movements = append(movements, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Movement rows")
+	}
+
+	return movements, nil
+}
+
+func SaveRunwayConfig(c *RunwayConfig) error {
+	res, err := db.Exec(`INSERT INTO RunwayConfigs(airport, time, arrivals, departures) VALUES(?, ?, ?, ?)`,
+		c.Airport, c.Time.UnixNano(), strings.Join(c.Arrivals, ","), strings.Join(c.Departures, ","))
+	if err != nil {
+		return errors.Wrap(err, "unable to write runway configuration")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		c.id = int(id)
+	}
+	return nil
+}
+
+func scanRunwayConfig(sc interface {
+	Scan(dest ...interface{}) error
+}) (*RunwayConfig, error) {
+	c := new(RunwayConfig)
+	var tt int64
+	var arr, dep string
+	err := sc.Scan(&c.id, &c.Airport, &tt, &arr, &dep)
+	if err != nil {
+		return nil, err
+	}
+	c.Time = time.Unix(0, tt)
+	if arr != "" {
+		c.Arrivals = strings.Split(arr, ",")
+	}
+	if dep != "" {
+		c.Departures = strings.Split(dep, ",")
+	}
+	return c, nil
+}
+
+func LoadLatestRunwayConfig(airport string) (*RunwayConfig, error) {
+	c, err := scanRunwayConfig(db.QueryRow(queryLatestRunwayConfig, airport))
+	if err == sql.ErrNoRows {
+		return nil, runwayConfigNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to load runway configuration of %q", airport))
+	}
+
+	return c, nil
+}
+
+func LoadRunwayConfigs(airport string, t time.Time) ([]*RunwayConfig, error) {
+	rows, err := db.Query(queryRunwayConfigs, airport, sinceNano(t))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load runway configurations")
+	}
+	defer rows.Close()
+
+	var configs []*RunwayConfig
+	for rows.Next() {
+		c, err := scanRunwayConfig(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from RunwayConfigs table")
+		}
+		configs = append(configs, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over RunwayConfig rows")
+	}
+
+	return configs, nil
+}
+
+func SavePhaseChange(c *PhaseChange) error {
+	res, err := db.Exec(`INSERT INTO PhaseChanges(icao, time, from_phase, to_phase, altitude, speed) VALUES(?, ?, ?, ?, ?, ?)`,
+		int(c.Icao), c.Time.UnixNano(), c.From, c.To, c.Altitude, c.Speed)
+	if err != nil {
+		return errors.Wrap(err, "unable to write phase change")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		c.id = int(id)
+	}
+	return nil
+}
+
+func LoadPhaseChanges(icao uint, t time.Time) ([]*PhaseChange, error) {
+	rows, err := db.Query(queryPhaseChanges, int(icao), sinceNano(t))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load phase changes")
+	}
+	defer rows.Close()
+
+	var changes []*PhaseChange
+	for rows.Next() {
+		c := new(PhaseChange)
+		var tt int64
+		var ic int
+		err = rows.Scan(&c.id, &ic, &tt, &c.From, &c.To, &c.Altitude, &c.Speed)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from PhaseChanges table")
+		}
+		c.Icao = uint(ic)
+		c.Time = time.Unix(0, tt)
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over PhaseChange rows")
+	}
+
+	return changes, nil
+}
+
+func SaveFlightEvent(e *FlightEvent) error {
+	res, err := db.Exec(`INSERT INTO FlightEvents(icao, kind, start_time, end_time, callsign, min_lat, min_lon, max_lat, max_lon, detail) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		int(e.Icao), e.Kind, e.Start.UnixNano(), e.End.UnixNano(), e.CallSign, e.Min.Lat, e.Min.Lon, e.Max.Lat, e.Max.Lon, e.Detail)
+	if err != nil {
+		return errors.Wrap(err, "unable to write flight event")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		e.id = int(id)
+	}
+	return nil
+}
+
+// LoadFlightEvents returns the events since the time. An icao of 0 or an empty kind match all.
+func LoadFlightEvents(icao uint, kind string, t time.Time) ([]*FlightEvent, error) {
+	rows, err := db.Query(queryFlightEvents, int(icao), int(icao), kind, kind, sinceNano(t))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load flight events")
+	}
+	defer rows.Close()
+
+	var events []*FlightEvent
+	for rows.Next() {
+		e := new(FlightEvent)
+		var start, end int64
+		var ic int
+		err = rows.Scan(&e.id, &ic, &e.Kind, &start, &end, &e.CallSign, &e.Min.Lat, &e.Min.Lon, &e.Max.Lat, &e.Max.Lon, &e.Detail)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from FlightEvents table")
+		}
+		e.Icao = uint(ic)
+		e.Start = time.Unix(0, start)
+		e.End = time.Unix(0, end)
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over FlightEvent rows")
+	}
+
+	return events, nil
+}
+
+func SaveEncounter(e *Encounter) error {
+	res, err := db.Exec(`INSERT INTO Encounters(icao1, icao2, callsign1, callsign2, start_time, end_time, cpa_time, distance, vertical, lat1, lon1, altitude1, lat2, lon2, altitude2)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		int(e.Icao1), int(e.Icao2), e.CallSign1, e.CallSign2, e.Start.UnixNano(), e.End.UnixNano(), e.Time.UnixNano(), e.Distance, e.Vertical,
+		e.Pos1.Latitude, e.Pos1.Longitude, e.Pos1.Altitude, e.Pos2.Latitude, e.Pos2.Longitude, e.Pos2.Altitude)
+	if err != nil {
+		return errors.Wrap(err, "unable to write encounter")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		e.id = int(id)
+	}
+	return nil
+}
+
+func LoadEncounters(t time.Time) ([]*Encounter, error) {
+	rows, err := db.Query(queryEncounters, sinceNano(t))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load encounters")
+	}
+	defer rows.Close()
+
+	var encounters []*Encounter
+	for rows.Next() {
+		e := new(Encounter)
+		var ic1, ic2 int
+		var start, end, cpa int64
+		err = rows.Scan(&e.id, &ic1, &ic2, &e.CallSign1, &e.CallSign2, &start, &end, &cpa, &e.Distance, &e.Vertical,
+			&e.Pos1.Latitude, &e.Pos1.Longitude, &e.Pos1.Altitude, &e.Pos2.Latitude, &e.Pos2.Longitude, &e.Pos2.Altitude)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Encounters table")
+		}
+		e.Icao1, e.Icao2 = uint(ic1), uint(ic2)
+		e.Start = time.Unix(0, start)
+		e.End = time.Unix(0, end)
+		e.Time = time.Unix(0, cpa)
+		e.Pos1.Time, e.Pos2.Time = e.Time, e.Time
+		encounters = append(encounters, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Encounter rows")
+	}
+
+	return encounters, nil
+}
+
+func SaveAnomaly(a *Anomaly) error {
+	res, err := db.Exec("INSERT INTO Anomalies(icao, time, kind, detail, callsign, lat, lon, altitude) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+		int(a.Icao), a.Time.UnixNano(), a.Kind, a.Detail, a.CallSign, a.Latitude, a.Longitude, a.Altitude)
+	if err != nil {
+		return errors.Wrap(err, "unable to write anomaly")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		a.id = int(id)
+	}
+	return nil
+}
+
+func LoadAnomalies(icao uint, t time.Time) ([]*Anomaly, error) {
+	rows, err := db.Query(queryAnomalies, int(icao), sinceNano(t))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load anomalies")
+	}
+	defer rows.Close()
+
+	var anomalies []*Anomaly
+	for rows.Next() {
+		a := &Anomaly{Icao: icao}
+		var at int64
+		var ic int
+		err = rows.Scan(&a.id, &ic, &at, &a.Kind, &a.Detail, &a.CallSign, &a.Latitude, &a.Longitude, &a.Altitude)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Anomalies table")
+		}
+		a.Time = time.Unix(0, at)
+		anomalies = append(anomalies, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Anomaly rows")
+	}
+
+	return anomalies, nil
+}
+
+// SaveWatchEntry adds the entry to the watchlist, or updates it if it already has an id.
+func SaveWatchEntry(w *WatchEntry) error {
+	if w.id != 0 {
+		_, err := db.Exec("UPDATE Watchlist SET kind = ?, value = ?, label = ?, note = ? WHERE id = ?", w.Kind, w.Value, w.Label, w.Note, w.id)
+		if err != nil {
+			return errors.Wrap(err, "unable to update watchlist entry")
+		}
+		return nil
+	}
+
+	res, err := db.Exec("INSERT INTO Watchlist(kind, value, label, note) VALUES(?, ?, ?, ?)", w.Kind, w.Value, w.Label, w.Note)
+	if err != nil {
+		return errors.Wrap(err, "unable to write watchlist entry")
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return errors.Wrap(err, "unable to read watchlist entry id")
+	}
+	w.id = int(id)
+	return nil
+}
+
+func DeleteWatchEntry(id int) error {
+	_, err := db.Exec("DELETE FROM Watchlist WHERE id = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "unable to delete watchlist entry")
+	}
+	return nil
+}
+
+func LoadWatchlist() ([]*WatchEntry, error) {
+	rows, err := db.Query(queryWatchlist)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load watchlist")
+	}
+	defer rows.Close()
+
+	var watchlist []*WatchEntry
+	for rows.Next() {
+		w := new(WatchEntry)
+		err = rows.Scan(&w.id, &w.Kind, &w.Value, &w.Label, &w.Note)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Watchlist table")
+		}
+		watchlist = append(watchlist, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Watchlist rows")
+	}
+
+	return watchlist, nil
+}
+
+// SaveRule adds the rule, or updates it if it already has an id. The sinks are stored one
+// per line.
+func SaveRule(r *Rule) error {
+	sinks := strings.Join(r.Sinks, "\n")
+	if r.id != 0 {
+		_, err := db.Exec("UPDATE Rules SET name = ?, expr = ?, cooldown = ?, sinks = ?, dry_run = ? WHERE id = ?",
+			r.Name, r.Expr, int64(r.Cooldown), sinks, r.DryRun, r.id)
+		if err != nil {
+			return errors.Wrap(err, "unable to update rule")
+		}
+		return nil
+	}
+
+	res, err := db.Exec("INSERT INTO Rules(name, expr, cooldown, sinks, dry_run) VALUES(?, ?, ?, ?, ?)",
+		r.Name, r.Expr, int64(r.Cooldown), sinks, r.DryRun)
+	if err != nil {
+		return errors.Wrap(err, "unable to write rule")
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return errors.Wrap(err, "unable to read rule id")
+	}
+	r.id = int(id)
+	return nil
+}
+
+func DeleteRule(id int) error {
+	_, err := db.Exec("DELETE FROM Rules WHERE id = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "unable to delete rule")
+	}
+	return nil
+}
+
+func LoadRules() ([]*Rule, error) {
+	rows, err := db.Query(queryRules)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load rules")
+	}
+	defer rows.Close()
+
+	var rules []*Rule
+	for rows.Next() {
+		r := new(Rule)
+		var cooldown int64
+		var sinks string
+		err = rows.Scan(&r.id, &r.Name, &r.Expr, &cooldown, &sinks, &r.DryRun)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Rules table")
+		}
+		r.Cooldown = time.Duration(cooldown)
+		if sinks != "" {
+			r.Sinks = strings.Split(sinks, "\n")
+		}
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Rule rows")
+	}
+
+	return rules, nil
+}
+
+func SaveLogEntry(e *LogEntry) error {
+	res, err := db.Exec(`INSERT INTO Logbook(icao, first_seen, last_seen, callsigns, squawks, min_altitude, max_altitude, max_speed, distance, closest, messages, positions)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		int(e.Icao), e.FirstSeen.UnixNano(), e.LastSeen.UnixNano(), strings.Join(e.CallSigns, ","), strings.Join(e.Squawks, ","),
+		e.MinAltitude, e.MaxAltitude, e.MaxSpeed, e.Distance, e.Closest, e.Messages, e.Positions)
+	if err != nil {
+		return errors.Wrap(err, "unable to write logbook entry")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		e.id = int(id)
+	}
+	return nil
+}
+
+// LoadLogEntries returns the logbook entries of visits which ended after the time. An icao
+// of 0 matches all.
+func LoadLogEntries(icao uint, t time.Time) ([]*LogEntry, error) {
+	rows, err := db.Query(queryLogbook, int(icao), int(icao), sinceNano(t))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load logbook")
+	}
+	defer rows.Close()
+
+	var entries []*LogEntry
+	for rows.Next() {
+		e := new(LogEntry)
+		var ic int
+		var first, last int64
+		var callsigns, squawks string
+		err = rows.Scan(&e.id, &ic, &first, &last, &callsigns, &squawks, &e.MinAltitude, &e.MaxAltitude, &e.MaxSpeed,
+			&e.Distance, &e.Closest, &e.Messages, &e.Positions)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Logbook table")
+		}
+		e.Icao = uint(ic)
+		e.FirstSeen = time.Unix(0, first)
+		e.LastSeen = time.Unix(0, last)
+		if callsigns != "" {
+			e.CallSigns = strings.Split(callsigns, ",")
+		}
+		if squawks != "" {
+			e.Squawks = strings.Split(squawks, ",")
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Logbook rows")
+	}
+
+	return entries, nil
+}
+
+func SaveSighting(s *Sighting) error {
+	res, err := db.Exec("INSERT INTO Sightings(icao, time, kind, previous) VALUES(?, ?, ?, ?)",
+		int(s.Icao), s.Time.UnixNano(), s.Kind, sinceNano(s.Previous))
+	if err != nil {
+		return errors.Wrap(err, "unable to write sighting")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		s.id = int(id)
+	}
+	return nil
+}
+
+func LoadSightings(t time.Time) ([]*Sighting, error) {
+	rows, err := db.Query(querySightings, sinceNano(t))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load sightings")
+	}
+	defer rows.Close()
+
+	var sightings []*Sighting
+	for rows.Next() {
+		s := new(Sighting)
+		var ic int
+		var st, prev int64
+		err = rows.Scan(&s.id, &ic, &st, &s.Kind, &prev)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Sightings table")
+		}
+		s.Icao = uint(ic)
+		s.Time = time.Unix(0, st)
+		if prev != 0 {
+			s.Previous = time.Unix(0, prev)
+		}
+		sightings = append(sightings, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Sighting rows")
+	}
+
+	return sightings, nil
+}
+
+func SaveOverflight(o *Overflight) error {
+	res, err := db.Exec(`INSERT INTO Overflights(point, icao, callsign, type, start_time, end_time, time, distance, altitude)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`, o.Point, int(o.Icao), o.CallSign, o.Type, o.Start.UnixNano(), o.End.UnixNano(),
+		o.Time.UnixNano(), o.Distance, o.Altitude)
+	if err != nil {
+		return errors.Wrap(err, "unable to write overflight")
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		o.id = int(id)
+	}
+	return nil
+}
+
+// LoadOverflights returns the overflights of the point from the start time until the end
+// time, or without an end if it is zero.
+func LoadOverflights(point string, start, end time.Time) ([]*Overflight, error) {
+	en := sinceNano(end)
+	rows, err := db.Query(queryOverflights, point, sinceNano(start), en, en)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load overflights")
+	}
+	defer rows.Close()
+
+	var ofs []*Overflight
+	for rows.Next() {
+		o := new(Overflight)
+		var ic int
+		var st, et, t int64
+		err = rows.Scan(&o.id, &o.Point, &ic, &o.CallSign, &o.Type, &st, &et, &t, &o.Distance, &o.Altitude)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load values from Overflights table")
+		}
+		o.Icao = uint(ic)
+		o.Start = time.Unix(0, st)
+		o.End = time.Unix(0, et)
+		o.Time = time.Unix(0, t)
+		ofs = append(ofs, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error iterating over Overflight rows")
+	}
+
+	return ofs, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kinds of event published on the bus.
+const (
+	BusAppeared = "appeared"
+	BusField    = "field"
+	BusPosition = "position"
+	BusSquawk   = "squawk"
+	BusState    = "state"
+	BusArchived = "archived"
+	BusNew      = "new aircraft"
+)
+
+// Length of a subscriber's queue if none is given.
+const defaultQueue = 256
+
+// BusEvent is a change to a plane. It holds copies of the values so subscribers, which run
+// in their own goroutines, never read the Plane itself.
+type BusEvent struct {
+	Kind     string
+	Icao     uint
+	CallSign string
+	Time     time.Time
+	Field    string    // name of the changed field, or the state for state events
+	Old      string    // previous value
+	New      string    // new value
+	Location *Location // for position events
+}
+
+func (e *BusEvent) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"kind\": %q, ", e.Kind))
+	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", e.Icao))
+	buf.WriteString(fmt.Sprintf("\"callsign\": %q, ", e.CallSign))
+	if e.Field != "" {
+		buf.WriteString(fmt.Sprintf("\"field\": %q, \"old\": %q, \"new\": %q, ", e.Field, e.Old, e.New))
+	}
+	if e.Location != nil {
+		// Subscribers run in their own goroutines, so always use the default units.
+		buf.WriteString(fmt.Sprintf("\"location\": \"%f,%f\", %s, ", e.Location.Latitude, e.Location.Longitude,
+			defaultUnits.altitude("altitude", e.Location.Altitude)))
+	}
+	buf.WriteString(fmt.Sprintf("\"time\": %q", e.Time.String()))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+// Subscriber receives the events it subscribed to. Handle is called from the subscription's
+// own goroutine, one event at a time, so a slow subscriber only delays itself.
+type Subscriber interface {
+	Name() string
+	Handle(e *BusEvent)
+}
+
+// Subscription is a subscriber attached to the bus with a bounded queue. Events published
+// while the queue is full are dropped and counted.
+type Subscription struct {
+	sub     Subscriber
+	kinds   map[string]bool
+	queue   chan *BusEvent
+	dropped int
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (s *Subscription) Dropped() int {
+	busLock.Lock()
+	defer busLock.Unlock()
+	return s.dropped
+}
+
+func (s *Subscription) run() {
+	for e := range s.queue {
+		s.sub.Handle(e)
+	}
+}
+
+var (
+	busLock       sync.Mutex
+	subscriptions []*Subscription
+)
+
+// Subscribe attaches the subscriber to the bus for the kinds of event, or all kinds if none
+// are given. The queue holds up to size events, or defaultQueue if size is not positive.
+func Subscribe(sub Subscriber, size int, kinds ...string) *Subscription {
+	if size <= 0 {
+		size = defaultQueue
+	}
+	s := &Subscription{sub: sub, queue: make(chan *BusEvent, size)}
+	if len(kinds) > 0 {
+		s.kinds = make(map[string]bool)
+		for _, k := range kinds {
+			s.kinds[k] = true
+		}
+	}
+
+	busLock.Lock()
+	subscriptions = append(subscriptions, s)
+	busLock.Unlock()
+
+	go s.run()
+	return s
+}
+
+// Unsubscribe detaches the subscription. Events already queued are still handled.
+func Unsubscribe(s *Subscription) {
+	busLock.Lock()
+	defer busLock.Unlock()
+
+	for i, o := range subscriptions {
+		if o == s {
+			subscriptions = append(subscriptions[:i], subscriptions[i+1:]...)
+			close(s.queue)
+			return
+		}
+	}
+}
+
+// publish queues the event for each subscriber to its kind, without blocking.
+func publish(e *BusEvent) {
+	busLock.Lock()
+	defer busLock.Unlock()
+
+	for _, s := range subscriptions {
+		if s.kinds != nil && !s.kinds[e.Kind] {
+			continue
+		}
+		select {
+		case s.queue <- e:
+		default:
+			if s.dropped == 0 {
+				fmt.Fprintf(os.Stderr, "event queue of %s is full, dropping events\n", s.sub.Name())
+			}
+			s.dropped++
+		}
+	}
+}
+
+// planeFields is a copy of the fields of a plane which are published when they change.
+type planeFields struct {
+	CallSign  string
+	Squawk    string
+	Altitude  int
+	Track     float32
+	Speed     float32
+	Vertical  int
+	SquawkCh  bool
+	Emergency bool
+	Ident     bool
+	OnGround  bool
+}
+
+func fieldsOf(pl *Plane) planeFields {
+	return planeFields{pl.CallSign, pl.Squawk, pl.Altitude, pl.Track, pl.Speed, pl.Vertical,
+		pl.SquawkCh, pl.Emergency, pl.Ident, pl.OnGround}
+}
+
+// publishChanges publishes an event for each field of the plane which differs from before.
+func publishChanges(before planeFields, pl *Plane, t time.Time) {
+	after := fieldsOf(pl)
+	if after == before {
+		return
+	}
+
+	changed := func(kind, field string, old, new interface{}) {
+		publish(&BusEvent{Kind: kind, Icao: pl.Icao, CallSign: pl.CallSign, Time: t, Field: field,
+			Old: fmt.Sprint(old), New: fmt.Sprint(new)})
+	}
+	if before.CallSign != after.CallSign {
+		changed(BusField, "callsign", before.CallSign, after.CallSign)
+	}
+	if before.Squawk != after.Squawk {
+		changed(BusSquawk, "squawk", before.Squawk, after.Squawk)
+	}
+	if before.Altitude != after.Altitude {
+		changed(BusField, "altitude", before.Altitude, after.Altitude)
+	}
+	if before.Track != after.Track {
+		changed(BusField, "track", before.Track, after.Track)
+	}
+	if before.Speed != after.Speed {
+		changed(BusField, "speed", before.Speed, after.Speed)
+	}
+	if before.Vertical != after.Vertical {
+		changed(BusField, "vertical", before.Vertical, after.Vertical)
+	}
+	if before.SquawkCh != after.SquawkCh {
+		changed(BusField, "squawkChange", before.SquawkCh, after.SquawkCh)
+	}
+	if before.Emergency != after.Emergency {
+		changed(BusField, "emergency", before.Emergency, after.Emergency)
+	}
+	if before.Ident != after.Ident {
+		changed(BusField, "ident", before.Ident, after.Ident)
+	}
+	if before.OnGround != after.OnGround {
+		changed(BusField, "onGround", before.OnGround, after.OnGround)
+	}
+}
+
+// publishPosition publishes the plane's latest location.
+func publishPosition(pl *Plane, t time.Time) {
+	if len(pl.Locations) == 0 {
+		return
+	}
+	l := pl.Locations[len(pl.Locations)-1]
+	publish(&BusEvent{Kind: BusPosition, Icao: pl.Icao, CallSign: pl.CallSign, Time: t, Location: &l})
+}
+
+// publishState publishes a change of lifecycle state. New planes have appeared and archived
+// planes are no longer active.
+func publishState(c *StateChange) {
+	e := &BusEvent{Kind: BusState, Icao: c.Icao, CallSign: c.CallSign, Time: c.Time, Field: "state", Old: c.From, New: c.To}
+	switch c.To {
+	case StateNew:
+		e.Kind = BusAppeared
+	case StateArchived:
+		e.Kind = BusArchived
+	}
+	publish(e)
+}
+
+// SinkSubscriber passes each event to a sink as JSON.
+type SinkSubscriber struct {
+	Sink Sink
+}
+
+func (s *SinkSubscriber) Name() string {
+	return s.Sink.Name()
+}
+
+func (s *SinkSubscriber) Handle(e *BusEvent) {
+	err := s.Sink.Deliver(e.ToJson())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error delivering to %s: %v\n", s.Sink.Name(), err)
+	}
+}
+
+// initEventLog subscribes a file sink to every event if an event log is configured.
+func initEventLog(path string) {
+	if path == "" {
+		return
+	}
+	Subscribe(&SinkSubscriber{Sink: &FileSink{Path: path}}, 0)
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expressions filter planes, for example:
+//
+//	altitude < 3000ft && distance < 5nm && !onGround
+//	squawk in ["7700", "7600"] || (military && phase == "approach")
+//
+// Values are numbers, strings or booleans. Numbers may have a unit suffix (ft, nm, kt,
+// fpm) which is ignored, as fields are always in those units. Identifiers are fields of
+// the plane, looked up when the expression is evaluated. Using a field the plane hasn't
+// reported yet, such as the distance before its first position, is an evaluation error.
+
+// Expr is a compiled expression.
+type Expr interface {
+	Eval(fields func(string) (interface{}, bool)) (interface{}, error)
+}
+
+type literal struct {
+	v interface{}
+}
+
+func (l *literal) Eval(fields func(string) (interface{}, bool)) (interface{}, error) {
+	return l.v, nil
+}
+
+type field struct {
+	name string
+}
+
+func (f *field) Eval(fields func(string) (interface{}, bool)) (interface{}, error) {
+	v, ok := fields(f.name)
+	if !ok {
+		return nil, fmt.Errorf("unknown field: %q", f.name)
+	}
+	if v == nil {
+		return nil, fmt.Errorf("%s is not known", f.name)
+	}
+	return v, nil
+}
+
+type not struct {
+	e Expr
+}
+
+func (n *not) Eval(fields func(string) (interface{}, bool)) (interface{}, error) {
+	v, err := n.e.Eval(fields)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! needs a boolean, not %v", v)
+	}
+	return !b, nil
+}
+
+type binary struct {
+	op          string
+	left, right Expr
+}
+
+func (b *binary) Eval(fields func(string) (interface{}, bool)) (interface{}, error) {
+	l, err := b.left.Eval(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit the logical operators.
+	if b.op == "&&" || b.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs booleans, not %v", b.op, l)
+		}
+		if (b.op == "&&" && !lb) || (b.op == "||" && lb) {
+			return lb, nil
+		}
+		r, err := b.right.Eval(fields)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs booleans, not %v", b.op, r)
+		}
+		return rb, nil
+	}
+
+	r, err := b.right.Eval(fields)
+	if err != nil {
+		return nil, err
+	}
+	return compare(b.op, l, r)
+}
+
+type in struct {
+	e    Expr
+	list []Expr
+}
+
+func (i *in) Eval(fields func(string) (interface{}, bool)) (interface{}, error) {
+	v, err := i.e.Eval(fields)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range i.list {
+		o, err := e.Eval(fields)
+		if err != nil {
+			return nil, err
+		}
+		if eq, err := compare("==", v, o); err == nil && eq.(bool) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compare applies a comparison operator. Strings compare without case.
+func compare(op string, l, r interface{}) (interface{}, error) {
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return nil, fmt.Errorf("can't compare number %v with %v", l, r)
+		}
+		switch op {
+		case "==":
+			return lv == rv, nil
+		case "!=":
+			return lv != rv, nil
+		case "<":
+			return lv < rv, nil
+		case "<=":
+			return lv <= rv, nil
+		case ">":
+			return lv > rv, nil
+		case ">=":
+			return lv >= rv, nil
+		}
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("can't compare string %q with %v", l, r)
+		}
+		c := strings.Compare(strings.ToUpper(lv), strings.ToUpper(rv))
+		switch op {
+		case "==":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		case ">=":
+			return c >= 0, nil
+		}
+	case bool:
+		rv, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("can't compare boolean %v with %v", l, r)
+		}
+		switch op {
+		case "==":
+			return lv == rv, nil
+		case "!=":
+			return lv != rv, nil
+		}
+	}
+	return nil, fmt.Errorf("can't use %s with %v", op, l)
+}
+
+type token struct {
+	kind string // "num", "str", "ident" or the operator itself
+	text string
+	num  float64
+}
+
+// Units numbers may be written with.
+var exprUnits = []string{"fpm", "ft", "nm", "kt"}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1]) && expectsValue(tokens)):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			n, err := strconv.ParseFloat(string(rs[i:j]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number: %q", string(rs[i:j]))
+			}
+			for _, u := range exprUnits {
+				if strings.HasPrefix(strings.ToLower(string(rs[j:])), u) {
+					j += len(u)
+					break
+				}
+			}
+			tokens = append(tokens, token{kind: "num", num: n})
+			i = j
+		case c == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			if j == len(rs) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{kind: "str", text: string(rs[i+1 : j])})
+			i = j + 1
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			word := string(rs[i:j])
+			if word == "in" {
+				tokens = append(tokens, token{kind: "in"})
+			} else {
+				tokens = append(tokens, token{kind: "ident", text: word})
+			}
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(string(rs[i:]), o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character: %q", c)
+			}
+			tokens = append(tokens, token{kind: op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// expectsValue returns true if a value rather than an operator comes next, so a minus sign
+// starts a negative number.
+func expectsValue(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	switch tokens[len(tokens)-1].kind {
+	case "num", "str", "ident", ")", "]":
+		return false
+	}
+	return true
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return ""
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(kind string) error {
+	if p.peek() != kind {
+		return fmt.Errorf("expected %q", kind)
+	}
+	p.pos++
+	return nil
+}
+
+// ParseExpr compiles the expression.
+func ParseExpr(s string) (Expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	p := &parser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+	return e, nil
+}
+
+func (p *parser) or() (Expr, error) {
+	e, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var r Expr
+		r, err = p.and()
+		e = &binary{op: "||", left: e, right: r}
+	}
+	return e, err
+}
+
+func (p *parser) and() (Expr, error) {
+	e, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var r Expr
+		r, err = p.unary()
+		e = &binary{op: "&&", left: e, right: r}
+	}
+	return e, err
+}
+
+func (p *parser) unary() (Expr, error) {
+	if p.peek() == "!" {
+		p.next()
+		e, err := p.unary()
+		return &not{e}, err
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (Expr, error) {
+	l, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		r, err := p.primary()
+		return &binary{op: op, left: l, right: r}, err
+	case "in":
+		p.next()
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		e := &in{e: l}
+		for p.peek() != "]" {
+			v, err := p.primary()
+			if err != nil {
+				return nil, err
+			}
+			e.list = append(e.list, v)
+			if p.peek() == "," {
+				p.next()
+			} else if p.peek() != "]" {
+				return nil, fmt.Errorf("expected \",\" or \"]\"")
+			}
+		}
+		p.next()
+		return e, nil
+	}
+	return l, nil
+}
+
+func (p *parser) primary() (Expr, error) {
+	switch p.peek() {
+	case "num":
+		return &literal{p.next().num}, nil
+	case "str":
+		return &literal{p.next().text}, nil
+	case "ident":
+		t := p.next()
+		switch t.text {
+		case "true":
+			return &literal{true}, nil
+		case "false":
+			return &literal{false}, nil
+		}
+		return &field{t.text}, nil
+	case "(":
+		p.next()
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", p.peek())
+}
+
+// planeField returns the value of a field of the plane for expressions, or nil if the plane
+// hasn't reported it. Each field must also be in planeFieldTypes.
+func planeField(pl *Plane, name string) (interface{}, bool) {
+	switch name {
+	case "icao":
+		return fmt.Sprintf("%06X", pl.Icao), true
+	case "callsign":
+		return pl.CallSign, true
+	case "registration":
+		return pl.Info.Registration, true
+	case "type":
+		return pl.Info.Type, true
+	case "operator":
+		return pl.Info.Operator, true
+	case "country":
+		return pl.Country, true
+	case "military":
+		return pl.Military, true
+	case "squawk":
+		return pl.Squawk, true
+	case "altitude":
+		if pl.Altitude == 0 {
+			return nil, true
+		}
+		return float64(pl.Altitude), true
+	case "geometric":
+		if pl.GeoAlt == 0 {
+			return nil, true
+		}
+		return float64(pl.GeoAlt), true
+	case "corrected":
+		if a, ok := correctedAltitude(pl); ok {
+			return float64(a), true
+		}
+		return nil, true
+	case "speed":
+		if pl.Speed == 0 {
+			return nil, true
+		}
+		return float64(pl.Speed), true
+	case "track":
+		if !pl.Velocity {
+			return nil, true
+		}
+		return float64(pl.Track), true
+	case "vertical":
+		if !pl.Velocity {
+			return nil, true
+		}
+		return float64(pl.Vertical), true
+	case "onGround":
+		return pl.OnGround, true
+	case "emergency":
+		return pl.Emergency, true
+	case "ident":
+		return pl.Ident, true
+	case "phase":
+		return pl.Phase, true
+	case "state":
+		return pl.State, true
+	case "anomaly":
+		return float64(pl.Anomaly), true
+	case "watched":
+		return pl.Watched != nil, true
+	case "lat", "lon", "distance":
+		if len(pl.Locations) == 0 {
+			return nil, true
+		}
+		l := pl.Locations[len(pl.Locations)-1]
+		switch name {
+		case "lat":
+			return float64(l.Latitude), true
+		case "lon":
+			return float64(l.Longitude), true
+		}
+		if !haveReceiver() {
+			return nil, true
+		}
+		return distanceNm(rxLat, rxLon, float64(l.Latitude), float64(l.Longitude)), true
+	}
+	return nil, false
+}
+
+// Types of the fields of a plane in expressions, which planeField returns.
+var planeFieldTypes = map[string]string{
+	"icao":         "string",
+	"callsign":     "string",
+	"registration": "string",
+	"type":         "string",
+	"operator":     "string",
+	"country":      "string",
+	"military":     "boolean",
+	"squawk":       "string",
+	"altitude":     "number",
+	"speed":        "number",
+	"track":        "number",
+	"vertical":     "number",
+	"onGround":     "boolean",
+	"emergency":    "boolean",
+	"ident":        "boolean",
+	"phase":        "string",
+	"state":        "string",
+	"anomaly":      "number",
+	"watched":      "boolean",
+	"lat":          "number",
+	"lon":          "number",
+	"distance":     "number",
+	"geometric":    "number",
+	"corrected":    "number",
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// typeOf returns the type of the expression, or an error for an unknown field or a value
+// of the wrong type anywhere in it.
+func typeOf(e Expr) (string, error) {
+	switch n := e.(type) {
+	case *literal:
+		return typeName(n.v), nil
+	case *field:
+		t, ok := planeFieldTypes[n.name]
+		if !ok {
+			return "", fmt.Errorf("unknown field: %q", n.name)
+		}
+		return t, nil
+	case *not:
+		t, err := typeOf(n.e)
+		if err != nil {
+			return "", err
+		}
+		if t != "boolean" {
+			return "", fmt.Errorf("! needs a boolean, not a %s", t)
+		}
+		return "boolean", nil
+	case *binary:
+		l, err := typeOf(n.left)
+		if err != nil {
+			return "", err
+		}
+		r, err := typeOf(n.right)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case n.op == "&&" || n.op == "||":
+			if l != "boolean" || r != "boolean" {
+				return "", fmt.Errorf("%s needs booleans, not a %s and a %s", n.op, l, r)
+			}
+		case l != r:
+			return "", fmt.Errorf("can't compare a %s with a %s", l, r)
+		case l == "boolean" && n.op != "==" && n.op != "!=":
+			return "", fmt.Errorf("can't use %s with booleans", n.op)
+		}
+		return "boolean", nil
+	case *in:
+		t, err := typeOf(n.e)
+		if err != nil {
+			return "", err
+		}
+		for _, o := range n.list {
+			ot, err := typeOf(o)
+			if err != nil {
+				return "", err
+			}
+			if ot != t {
+				return "", fmt.Errorf("can't look for a %s in a list with a %s", t, ot)
+			}
+		}
+		return "boolean", nil
+	}
+	return "", fmt.Errorf("unknown expression %T", e)
+}
+
+// checkFields checks every part of the expression for unknown fields and type errors,
+// without evaluating it, and that it is true or false.
+func checkFields(e Expr) error {
+	t, err := typeOf(e)
+	if err != nil {
+		return err
+	}
+	if t != "boolean" {
+		return fmt.Errorf("expression must be true or false, not a %s", t)
+	}
+	return nil
+}
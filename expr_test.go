@@ -0,0 +1,179 @@
+package main
+
+import (
+	"testing"
+)
+
+func testFields(values map[string]interface{}) func(string) (interface{}, bool) {
+	return func(name string) (interface{}, bool) {
+		v, ok := values[name]
+		return v, ok
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"altitude <",
+		"(altitude < 3",
+		"altitude < 3)",
+		"callsign == \"UAL",
+		"altitude # 3",
+		"altitude < 3 3",
+		"squawk in \"7700\"",
+		"squawk in [\"7700\" \"7600\"]",
+		"squawk in [\"7700\",",
+		"&& onGround",
+		"1.2.3 > altitude",
+	}
+	for _, s := range tests {
+		if _, err := ParseExpr(s); err == nil {
+			t.Errorf("ParseExpr(%q) succeeded, expected an error", s)
+		}
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	fields := testFields(map[string]interface{}{
+		"altitude":  2500.0,
+		"vertical":  -1000.0,
+		"distance":  4.2,
+		"callsign":  "UAL123",
+		"squawk":    "7600",
+		"onGround":  false,
+		"military":  true,
+		"geometric": nil,
+	})
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"false || !true || true", true},
+		{"altitude < 3000ft && distance < 5nm && !onGround", true},
+		{"altitude >= 2500 && altitude <= 2500", true},
+		{"altitude != 2500", false},
+		{"vertical < -500fpm", true},
+		{"vertical<-500", true},
+		{"3 < -2", false},
+		{"callsign == \"ual123\"", true},
+		{"callsign > \"UAL100\"", true},
+		{"squawk in [\"7700\", \"7600\"]", true},
+		{"squawk in [\"7700\"]", false},
+		{"squawk in []", false},
+		{"callsign in [\"ual123\"]", true},
+		{"altitude in [1000, 2500ft]", true},
+		{"military == true && onGround != true", true},
+		{"military && altitude < 1000 || squawk == \"7600\"", true},
+		{"onGround && geometric < 1000", false},
+	}
+	for _, test := range tests {
+		e, err := ParseExpr(test.expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", test.expr, err)
+			continue
+		}
+		v, err := e.Eval(fields)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", test.expr, err)
+			continue
+		}
+		if v != test.want {
+			t.Errorf("Eval(%q) = %v, want %v", test.expr, v, test.want)
+		}
+	}
+
+	// A plane which has only sent its callsign matches nothing about its position or movement.
+	pl := &Plane{Icao: 0xA00001, CallSign: "UAL123"}
+	planeTests := []struct {
+		expr string
+		want bool
+	}{
+		{"distance > 100nm", false},
+		{"distance < 100nm", false},
+		{"lat > 40 || lon < -100", false},
+		{"altitude < 3000 && !onGround", false},
+		{"speed < 50kt", false},
+		{"track >= 0", false},
+		{"vertical == 0", false},
+		{"!onGround && callsign == \"UAL123\"", true},
+	}
+	for _, test := range planeTests {
+		e, err := ParseExpr(test.expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", test.expr, err)
+			continue
+		}
+		if got := evalRule(e, pl); got != test.want {
+			t.Errorf("evalRule(%q) for a plane without a position = %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestCheckFields(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"altitude < 3000ft && distance < 5nm && !onGround", true},
+		{"squawk in [\"7700\", \"7600\"] || (military && phase == \"approach\")", true},
+		{"watched", true},
+		{"unknown == 1", false},
+		{"military || unknown", false},
+		{"altitude", false},
+		{"callsign", false},
+		{"!altitude", false},
+		{"military && altitude < \"x\"", false},
+		{"true || callsign == 3", false},
+		{"false && !speed", false},
+		{"onGround < true", false},
+		{"altitude && military", false},
+		{"squawk in [7700]", false},
+		{"squawk in [\"7700\", 7600]", false},
+		{"altitude in [callsign]", false},
+	}
+	for _, test := range tests {
+		e, err := ParseExpr(test.expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", test.expr, err)
+			continue
+		}
+		err = checkFields(e)
+		if test.ok && err != nil {
+			t.Errorf("checkFields(%q): %v", test.expr, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("checkFields(%q) succeeded, expected an error", test.expr)
+		}
+	}
+}
+
+func TestMissingField(t *testing.T) {
+	e, err := ParseExpr("geometric < 1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Eval(testFields(map[string]interface{}{"geometric": nil})); err == nil {
+		t.Errorf("Eval with a missing geometric altitude succeeded, expected an error")
+	}
+}
+
+func TestPlaneFieldTypes(t *testing.T) {
+	pl := &Plane{Altitude: 2000, GeoAlt: 2200, Speed: 150, Velocity: true, Locations: []Location{{Latitude: 37.5, Longitude: -122.3}}}
+	for name, want := range planeFieldTypes {
+		v, ok := planeField(pl, name)
+		if !ok {
+			t.Errorf("planeField(%q) is unknown", name)
+			continue
+		}
+		if v == nil {
+			// Not reported, such as the corrected altitude without a QNH.
+			continue
+		}
+		if got := typeName(v); got != want {
+			t.Errorf("planeField(%q) is a %s, want a %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import "math"
+
+// Mean radius of the earth in nautical miles.
+const earthRadiusNm = 3440.065
+
+func toRad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func toDeg(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+// distanceNm returns the great circle distance in nautical miles between two points.
+func distanceNm(lat1, lon1, lat2, lon2 float64) float64 {
+	p1, p2 := toRad(lat1), toRad(lat2)
+	dp := p2 - p1
+	dl := toRad(lon2 - lon1)
+
+	a := math.Sin(dp/2)*math.Sin(dp/2) + math.Cos(p1)*math.Cos(p2)*math.Sin(dl/2)*math.Sin(dl/2)
+	return earthRadiusNm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// bearing returns the initial bearing in degrees (0-360) from the first point to the second.
+func bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	p1, p2 := toRad(lat1), toRad(lat2)
+	dl := toRad(lon2 - lon1)
+
+	y := math.Sin(dl) * math.Cos(p2)
+	x := math.Cos(p1)*math.Sin(p2) - math.Sin(p1)*math.Cos(p2)*math.Cos(dl)
+	return math.Mod(toDeg(math.Atan2(y, x))+360, 360)
+}
+
+// destination returns the point reached travelling dist nautical miles from lat/lon on
+// the specified bearing.
+func destination(lat, lon, brg, dist float64) (float64, float64) {
+	p1 := toRad(lat)
+	l1 := toRad(lon)
+	d := dist / earthRadiusNm
+	b := toRad(brg)
+
+	p2 := math.Asin(math.Sin(p1)*math.Cos(d) + math.Cos(p1)*math.Sin(d)*math.Cos(b))
+	l2 := l1 + math.Atan2(math.Sin(b)*math.Sin(d)*math.Cos(p1), math.Cos(d)-math.Sin(p1)*math.Sin(p2))
+
+	return toDeg(p2), math.Mod(toDeg(l2)+540, 360) - 180
+}
+
+type point struct {
+	Lat float64
+	Lon float64
+}
+
+// polygon is a list of rings. The first ring is the outer boundary, any others are holes.
+type polygon [][]point
+
+// ringContains uses ray casting to test if the point is inside the ring.
+func ringContains(ring []point, lat, lon float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > lat) != (b.Lat > lat) && lon < (b.Lon-a.Lon)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Contains returns true if the point is inside the outer ring and not in any holes.
+func (p polygon) Contains(lat, lon float64) bool {
+	if len(p) == 0 || !ringContains(p[0], lat, lon) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if ringContains(hole, lat, lon) {
+			return false
+		}
+	}
+	return true
+}
+
+// Bounds returns the south west and north east corners of the polygon.
+func (p polygon) Bounds() (point, point) {
+	min := point{Lat: 90, Lon: 180}
+	max := point{Lat: -90, Lon: -180}
+	for _, ring := range p {
+		for _, pt := range ring {
+			min.Lat = math.Min(min.Lat, pt.Lat)
+			min.Lon = math.Min(min.Lon, pt.Lon)
+			max.Lat = math.Max(max.Lat, pt.Lat)
+			max.Lon = math.Max(max.Lon, pt.Lon)
+		}
+	}
+	return min, max
+}
+
+// gridIndex buckets items into one degree cells by their bounding box, to quickly
+// find the items which may contain a position.
+type gridIndex struct {
+	cells map[[2]int][]int
+}
+
+func newGridIndex() *gridIndex {
+	return &gridIndex{cells: make(map[[2]int][]int)}
+}
+
+func gridCell(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat)), int(math.Floor(lon))}
+}
+
+// Insert adds the item id to each cell covered by the bounding box.
+func (g *gridIndex) Insert(id int, min, max point) {
+	lo := gridCell(min.Lat, min.Lon)
+	hi := gridCell(max.Lat, max.Lon)
+	for y := lo[0]; y <= hi[0]; y++ {
+		for x := lo[1]; x <= hi[1]; x++ {
+			g.cells[[2]int{y, x}] = append(g.cells[[2]int{y, x}], id)
+		}
+	}
+}
+
+// Query returns the ids of the items whose bounding box may contain the position.
+func (g *gridIndex) Query(lat, lon float64) []int {
+	return g.cells[gridCell(lat, lon)]
+}
+
+// distanceToSegment returns the distance in nautical miles from the point p to the great
+// circle path between a and b.
+func distanceToSegment(p, a, b point) float64 {
+	d13 := distanceNm(a.Lat, a.Lon, p.Lat, p.Lon) / earthRadiusNm
+	d12 := distanceNm(a.Lat, a.Lon, b.Lat, b.Lon) / earthRadiusNm
+	t13 := toRad(bearing(a.Lat, a.Lon, p.Lat, p.Lon))
+	t12 := toRad(bearing(a.Lat, a.Lon, b.Lat, b.Lon))
+
+	dxt := math.Asin(math.Sin(d13) * math.Sin(t13-t12))
+	dat := math.Acos(math.Max(-1, math.Min(1, math.Cos(d13)/math.Cos(dxt))))
+
+	if math.Cos(t13-t12) < 0 || dat > d12 {
+		// Beyond either end of the path, so use the nearest end.
+		return math.Min(d13*earthRadiusNm, distanceNm(b.Lat, b.Lon, p.Lat, p.Lon))
+	}
+	return math.Abs(dxt) * earthRadiusNm
+}
+
+// crossTrackNm returns the distance in nautical miles from the point p to the great
+// circle through a and b, extended beyond both points.
+func crossTrackNm(p, a, b point) float64 {
+	d13 := distanceNm(a.Lat, a.Lon, p.Lat, p.Lon) / earthRadiusNm
+	t13 := toRad(bearing(a.Lat, a.Lon, p.Lat, p.Lon))
+	t12 := toRad(bearing(a.Lat, a.Lon, b.Lat, b.Lon))
+
+	return math.Abs(math.Asin(math.Sin(d13)*math.Sin(t13-t12))) * earthRadiusNm
+}
+
+// headingDiff returns the difference in degrees (0-180) between two headings.
+func headingDiff(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Geofence is a named area with an optional altitude floor and ceiling, in feet.
+// A value of 0 means there is no limit.
+type Geofence struct {
+	Name     string
+	Floor    int
+	Ceiling  int
+	Polygons []polygon
+}
+
+// Contains returns true if the position is within the fence.
+func (g *Geofence) Contains(lat, lon float64, alt int) bool {
+	if g.Floor != 0 && alt < g.Floor {
+		return false
+	}
+	if g.Ceiling != 0 && alt > g.Ceiling {
+		return false
+	}
+
+	for _, p := range g.Polygons {
+		if p.Contains(lat, lon) {
+			return true
+		}
+	}
+	return false
+}
+
+type FenceEvent struct {
+	id        int
+	Fence     string
+	Icao      uint
+	Time      time.Time
+	State     string
+	CallSign  string
+	Latitude  float32
+	Longitude float32
+	Altitude  int
+}
+
+func (e *FenceEvent) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", e.id))
+	buf.WriteString(fmt.Sprintf("\"fence\": %q, ", e.Fence))
+	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", e.Icao))
+	buf.WriteString(fmt.Sprintf("\"time\": %q, ", e.Time.String()))
+	buf.WriteString(fmt.Sprintf("\"state\": %q, ", e.State))
+	buf.WriteString(fmt.Sprintf("\"callsign\": %q, ", e.CallSign))
+	buf.WriteString(fmt.Sprintf("\"location\": \"%f,%f\", ", e.Latitude, e.Longitude))
+	buf.WriteString(units.altitude("altitude", e.Altitude))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+var (
+	geofences []*Geofence
+	// Names of the fences each plane is currently inside.
+	fenceStates = make(map[uint]map[string]bool)
+)
+
+type geoJsonGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type geoJsonFeature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   *geoJsonGeometry       `json:"geometry"`
+}
+
+type geoJsonCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJsonFeature `json:"features"`
+}
+
+// loadGeofences reads each comma separated GeoJSON file. Each Polygon or MultiPolygon
+// feature becomes a fence, named by its "name" property. The "floor" and "ceiling"
+// properties optionally limit the altitude.
+func loadGeofences(files string) error {
+	if files == "" {
+		return nil
+	}
+
+	for _, file := range strings.Split(files, ",") {
+		b, err := ioutil.ReadFile(strings.TrimSpace(file))
+		if err != nil {
+			return errors.Wrap(err, "unable to read geofence file")
+		}
+
+		var fc geoJsonCollection
+		err = json.Unmarshal(b, &fc)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to parse geofence file %q", file))
+		}
+		if fc.Type == "Feature" {
+			var f geoJsonFeature
+			err = json.Unmarshal(b, &f)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("unable to parse geofence file %q", file))
+			}
+			fc.Features = []geoJsonFeature{f}
+		}
+
+		for i, f := range fc.Features {
+			g, err := parseGeofence(f)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("invalid feature %d in %q", i, file))
+			}
+			if g == nil {
+				continue
+			}
+			if g.Name == "" {
+				g.Name = fmt.Sprintf("fence%d", len(geofences)+1)
+			}
+			// Fences are known by their name, in their state and events.
+			if findGeofence(g.Name) != nil {
+				return fmt.Errorf("duplicate geofence name %q in %q", g.Name, file)
+			}
+			geofences = append(geofences, g)
+		}
+	}
+
+	if verbose {
+		fmt.Printf("Loaded %d geofences\n", len(geofences))
+	}
+
+	return nil
+}
+
+func findGeofence(name string) *Geofence {
+	for _, g := range geofences {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// parseGeofence converts a GeoJSON feature to a Geofence. Features which are not
+// polygons return nil.
+func parseGeofence(f geoJsonFeature) (*Geofence, error) {
+	if f.Geometry == nil {
+		return nil, nil
+	}
+
+	g := &Geofence{}
+	if name, ok := f.Properties["name"].(string); ok {
+		g.Name = name
+	}
+	if floor, ok := f.Properties["floor"].(float64); ok {
+		g.Floor = int(floor)
+	}
+	if ceiling, ok := f.Properties["ceiling"].(float64); ok {
+		g.Ceiling = int(ceiling)
+	}
+
+	switch f.Geometry.Type {
+	case "Polygon":
+		var coords [][][]float64
+		err := json.Unmarshal(f.Geometry.Coordinates, &coords)
+		if err != nil {
+			return nil, err
+		}
+		g.Polygons = append(g.Polygons, geoJsonPolygon(coords))
+	case "MultiPolygon":
+		var coords [][][][]float64
+		err := json.Unmarshal(f.Geometry.Coordinates, &coords)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range coords {
+			g.Polygons = append(g.Polygons, geoJsonPolygon(c))
+		}
+	default:
+		return nil, nil
+	}
+
+	return g, nil
+}
+
+// geoJsonPolygon converts GeoJSON [lon, lat] rings into a polygon.
+func geoJsonPolygon(coords [][][]float64) polygon {
+	p := make(polygon, 0, len(coords))
+	for _, c := range coords {
+		ring := make([]point, 0, len(c))
+		for _, pos := range c {
+			if len(pos) < 2 {
+				continue
+			}
+			ring = append(ring, point{Lat: pos[1], Lon: pos[0]})
+		}
+		p = append(p, ring)
+	}
+	return p
+}
+
+// checkGeofences evaluates the plane's latest position against each fence and raises
+// enter and exit events.
+func checkGeofences(pl *Plane) {
+	if len(geofences) == 0 || len(pl.Locations) == 0 {
+		return
+	}
+
+	l := pl.Locations[len(pl.Locations)-1]
+	inside := fenceStates[pl.Icao]
+
+	for _, g := range geofences {
+		in := g.Contains(float64(l.Latitude), float64(l.Longitude), pl.Altitude)
+		if in == inside[g.Name] {
+			continue
+		}
+
+		state := AlertExit
+		if in {
+			state = AlertEnter
+			if inside == nil {
+				inside = make(map[string]bool)
+				fenceStates[pl.Icao] = inside
+			}
+			inside[g.Name] = true
+		} else {
+			delete(inside, g.Name)
+		}
+
+		raiseFenceEvent(newFenceEvent(pl, g.Name, state), false)
+	}
+
+	if inside != nil && len(inside) == 0 {
+		delete(fenceStates, pl.Icao)
+	}
+}
+
+func newFenceEvent(pl *Plane, fence, state string) *FenceEvent {
+	l := pl.Locations[len(pl.Locations)-1]
+	return &FenceEvent{Fence: fence, Icao: pl.Icao, Time: l.Time, State: state, CallSign: pl.CallSign, Latitude: l.Latitude, Longitude: l.Longitude, Altitude: pl.Altitude}
+}
+
+// raiseFenceEvent stores the event, before returning if wait is true.
+func raiseFenceEvent(e *FenceEvent, wait bool) {
+	if verbose {
+		fmt.Printf("Geofence: %06X %s %s\n", e.Icao, e.State, e.Fence)
+	}
+	save := func() {
+		err := SaveFenceEvent(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving geofence event: %v\n", err)
+		}
+	}
+	if wait {
+		save()
+	} else {
+		go save()
+	}
+}
+
+// forgetGeofences exits the fences a plane which is no longer active was inside, at its
+// last location, so each enter event has an exit. When shutting down, the time is zero
+// and the events are saved before returning.
+func forgetGeofences(pl *Plane, t time.Time) {
+	for name := range fenceStates[pl.Icao] {
+		raiseFenceEvent(newFenceEvent(pl, name, AlertExit), t.IsZero())
+	}
+	delete(fenceStates, pl.Icao)
+}
+
+func listGeofences() string {
+	counts := make(map[string]int)
+	for _, inside := range fenceStates {
+		for name := range inside {
+			counts[name]++
+		}
+	}
+
+	sl := make([]string, len(geofences))
+	for i, g := range geofences {
+		sl[i] = fmt.Sprintf("{\"name\": %q, %s, %s, \"inside\": %d}", g.Name, units.altitude("floor", g.Floor),
+			units.altitude("ceiling", g.Ceiling), counts[g.Name])
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
+
+func getFenceEvents(fence string, t time.Time) string {
+	events, err := LoadFenceEvents(fence, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading geofence events: %v\n", err)
+		return "[]"
+	}
+
+	sl := make([]string, len(events))
+	for i, e := range events {
+		sl[i] = e.ToJson()
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
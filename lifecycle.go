@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	StateNew      = "new"
+	StateActive   = "active"
+	StateStale    = "stale"
+	StateLost     = "lost"
+	StateArchived = "archived"
+)
+
+const (
+	// How often the states of the active planes are checked.
+	lifecycleScan = time.Second * 5
+	// Time a plane is new for after it is first seen.
+	newPeriod = time.Second * 30
+	// Number of recent state changes kept for clients.
+	maxStateChanges = 1000
+)
+
+// StateChange is a plane moving from one lifecycle state to another.
+type StateChange struct {
+	Icao     uint
+	CallSign string
+	From     string
+	To       string
+	Time     time.Time
+}
+
+func (c *StateChange) ToJson() string {
+	return fmt.Sprintf("{\"icao\": \"%06X\", \"callsign\": %q, \"from\": %q, \"to\": %q, \"time\": %q}",
+		c.Icao, c.CallSign, c.From, c.To, c.Time.String())
+}
+
+// Recent state changes, oldest first.
+var stateChanges []*StateChange
+
+// setState moves the plane to the state, recording the change.
+func setState(pl *Plane, state string, t time.Time) {
+	if pl.State == state {
+		return
+	}
+
+	t = t.Round(0) // drop the monotonic clock reading from tick times
+	c := &StateChange{Icao: pl.Icao, CallSign: pl.CallSign, From: pl.State, To: state, Time: t}
+	pl.State = state
+	pl.StateTime = t
+
+	if len(stateChanges) >= maxStateChanges {
+		stateChanges = stateChanges[1:]
+	}
+	stateChanges = append(stateChanges, c)
+	publishState(c)
+
+	if verbose {
+		fmt.Printf("State: %06X (%s) %s -> %s\n", c.Icao, c.CallSign, c.From, c.To)
+	}
+}
+
+// planeSeen makes a stale or lost plane active again when a message is received from it.
+func planeSeen(pl *Plane, t time.Time) {
+	switch pl.State {
+	case "":
+		setState(pl, StateNew, t)
+	case StateStale, StateLost:
+		setState(pl, StateActive, t)
+	}
+}
+
+// checkLifecycles moves the active planes on to the state for how long it has been
+// since they were first and last seen.
+func checkLifecycles(t time.Time) {
+	for _, pl := range planeCache {
+		quiet := t.Sub(pl.LastSeen)
+		switch {
+		case quiet >= lostAfter:
+			setState(pl, StateLost, t)
+		case quiet >= staleAfter:
+			setState(pl, StateStale, t)
+		case pl.State == StateNew && t.Sub(pl.StateTime) >= newPeriod:
+			setState(pl, StateActive, t)
+		}
+	}
+}
+
+// getStateChanges returns the recent state changes since the time, optionally only those
+// to the comma separated states.
+func getStateChanges(t time.Time, states string) string {
+	var sl []string
+	for _, c := range stateChanges {
+		if !c.Time.After(t) {
+			continue
+		}
+		if states != "" {
+			found := false
+			for _, s := range strings.Split(states, ",") {
+				if strings.EqualFold(strings.TrimSpace(s), c.To) {
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		sl = append(sl, c.ToJson())
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogEntry summarises one visit of an aircraft, from when it became active until it was
+// saved and removed.
+type LogEntry struct {
+	id          int
+	Icao        uint
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	CallSigns   []string
+	Squawks     []string
+	MinAltitude int
+	MaxAltitude int
+	MaxSpeed    float32
+	Distance    float64 // distance flown in nm
+	Closest     float64 // closest distance to the receiver in nm, or -1 if unknown
+	Messages    int
+	Positions   int
+
+	last *Location // last position, to add up the distance flown
+}
+
+func (e *LogEntry) ToJson(active bool) string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", e.id))
+	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", e.Icao))
+	buf.WriteString(fmt.Sprintf("\"firstSeen\": %q, ", e.FirstSeen.String()))
+	buf.WriteString(fmt.Sprintf("\"lastSeen\": %q, ", e.LastSeen.String()))
+	buf.WriteString(fmt.Sprintf("\"callsigns\": %s, ", jsonStrings(e.CallSigns)))
+	buf.WriteString(fmt.Sprintf("\"squawks\": %s, ", jsonStrings(e.Squawks)))
+	buf.WriteString(units.altitude("minAltitude", e.MinAltitude) + ", ")
+	buf.WriteString(units.altitude("maxAltitude", e.MaxAltitude) + ", ")
+	buf.WriteString(units.speed("maxSpeed", float64(e.MaxSpeed)) + ", ")
+	buf.WriteString(units.distance("distance", e.Distance) + ", ")
+	if e.Closest >= 0 {
+		buf.WriteString(units.distance("closest", e.Closest) + ", ")
+	} else {
+		buf.WriteString("\"closest\": null, ")
+	}
+	buf.WriteString(fmt.Sprintf("\"messages\": %d, ", e.Messages))
+	buf.WriteString(fmt.Sprintf("\"positions\": %d, ", e.Positions))
+	buf.WriteString(fmt.Sprintf("\"active\": %v", active))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+// logbookCsvHeader returns the names of the CSV columns, with the units of the values.
+func logbookCsvHeader() string {
+	return fmt.Sprintf("icao,first_seen,last_seen,callsigns,squawks,min_altitude_%[1]s,max_altitude_%[1]s,max_speed_%[2]s,"+
+		"distance_%[3]s,closest_%[3]s,messages,positions", csvUnit(units.Altitude), csvUnit(units.Speed), csvUnit(units.Distance))
+}
+
+func (e *LogEntry) ToCsv() string {
+	closest := ""
+	if e.Closest >= 0 {
+		closest = units.distanceValue(e.Closest)
+	}
+	return fmt.Sprintf("%06X,%s,%s,%s,%s,%s,%s,%s,%s,%s,%d,%d", e.Icao, e.FirstSeen.UTC().Format(time.RFC3339),
+		e.LastSeen.UTC().Format(time.RFC3339), strings.Join(e.CallSigns, " "), strings.Join(e.Squawks, " "),
+		units.altitudeValue(e.MinAltitude), units.altitudeValue(e.MaxAltitude), units.speedValue(float64(e.MaxSpeed)),
+		units.distanceValue(e.Distance), closest, e.Messages, e.Positions)
+}
+
+// Logbook entries of the active planes.
+var logEntries = make(map[uint]*LogEntry)
+
+func appendNew(sl []string, s string) []string {
+	if s == "" {
+		return sl
+	}
+	for _, o := range sl {
+		if o == s {
+			return sl
+		}
+	}
+	return append(sl, s)
+}
+
+// updateLogbook adds the message to the plane's logbook entry.
+func updateLogbook(pl *Plane, moved bool, t time.Time) {
+	e, ok := logEntries[pl.Icao]
+	if !ok {
+		e = &LogEntry{Icao: pl.Icao, FirstSeen: t, Closest: -1}
+		logEntries[pl.Icao] = e
+	}
+
+	e.Messages++
+	if t.After(e.LastSeen) {
+		e.LastSeen = t
+	}
+	if t.Before(e.FirstSeen) {
+		e.FirstSeen = t
+	}
+	e.CallSigns = appendNew(e.CallSigns, pl.CallSign)
+	e.Squawks = appendNew(e.Squawks, pl.Squawk)
+	if pl.Altitude != 0 {
+		if e.MinAltitude == 0 || pl.Altitude < e.MinAltitude {
+			e.MinAltitude = pl.Altitude
+		}
+		if pl.Altitude > e.MaxAltitude {
+			e.MaxAltitude = pl.Altitude
+		}
+	}
+	if pl.Speed > e.MaxSpeed {
+		e.MaxSpeed = pl.Speed
+	}
+
+	if !moved || len(pl.Locations) == 0 {
+		return
+	}
+	l := pl.Locations[len(pl.Locations)-1]
+	e.Positions++
+
+	if e.last != nil {
+		d := distanceNm(float64(e.last.Latitude), float64(e.last.Longitude), float64(l.Latitude), float64(l.Longitude))
+		dt := math.Max(math.Abs(l.Time.Sub(e.last.Time).Hours()), 1.0/3600)
+		// Leave out jumps no aircraft could fly, from a duplicate ICAO or a bad position.
+		if d <= minJump || d/dt <= maxPlausibleSpeed {
+			e.Distance += d
+		}
+	}
+	e.last = &l
+
+	if haveReceiver() {
+		d := distanceNm(rxLat, rxLon, float64(l.Latitude), float64(l.Longitude))
+		if e.Closest < 0 || d < e.Closest {
+			e.Closest = d
+		}
+	}
+}
+
+// saveLogbook stores the logbook entry of a plane which is no longer active. When shutting
+// down, the time is zero and the entry is saved before returning.
+func saveLogbook(icao uint, t time.Time) {
+	e, ok := logEntries[icao]
+	if !ok {
+		return
+	}
+	delete(logEntries, icao)
+
+	save := func() {
+		err := SaveLogEntry(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving logbook entry: %v\n", err)
+		}
+	}
+	if t.IsZero() {
+		save()
+	} else {
+		go save()
+	}
+}
+
+// searchLogbook returns the logbook entries of visits since the time, followed by those in
+// progress. They can be filtered by icao, a callsign pattern and the time they started
+// before (u, in seconds).
+func searchLogbook(t time.Time, filter url.Values) ([]*LogEntry, int) {
+	var icao uint
+	if s := filter.Get("icao"); s != "" {
+		i, err := strconv.ParseUint(s, 16, 0)
+		if err != nil {
+			return nil, 0
+		}
+		icao = uint(i)
+	}
+	var until time.Time
+	if s := filter.Get("u"); s != "" {
+		if u, err := strconv.ParseInt(s, 10, 64); err == nil {
+			until = time.Unix(u, 0)
+		}
+	}
+	cs := filter.Get("callsign")
+
+	matches := func(e *LogEntry) bool {
+		if (icao != 0 && e.Icao != icao) || e.LastSeen.Before(t) || (!until.IsZero() && e.FirstSeen.After(until)) {
+			return false
+		}
+		if cs == "" {
+			return true
+		}
+		for _, c := range e.CallSigns {
+			if globMatch(cs, c) {
+				return true
+			}
+		}
+		return false
+	}
+
+	stored, err := LoadLogEntries(icao, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading logbook: %v\n", err)
+	}
+
+	var entries []*LogEntry
+	for _, e := range stored {
+		if matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	n := len(entries)
+
+	var active []*LogEntry
+	for _, e := range logEntries {
+		if matches(e) {
+			active = append(active, e)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].FirstSeen.Before(active[j].FirstSeen) })
+
+	return append(entries, active...), n
+}
+
+func getLogbook(t time.Time, filter url.Values) string {
+	entries, stored := searchLogbook(t, filter)
+
+	if filter.Get("format") == "csv" {
+		lines := []string{logbookCsvHeader()}
+		for _, e := range entries {
+			lines = append(lines, e.ToCsv())
+		}
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	sl := make([]string, len(entries))
+	for i, e := range entries {
+		sl[i] = e.ToJson(i >= stored)
+	}
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
@@ -19,6 +19,56 @@ var (
 	port        uint
 	verbose     bool
 	veryVerbose bool
+
+	// Receiver details
+	rxName string
+	rxLat  float64
+	rxLon  float64
+
+	// Alerting
+	alertWebhook  string
+	alertExec     string
+	alertLog      string
+	alertCooldown time.Duration
+
+	// Geofences
+	fenceFiles string
+
+	// Airspace
+	airspaceFiles string
+	vfrSquawks    string
+
+	// Routes
+	routeTolerance float64
+
+	// Airports
+	runwayAirports string
+
+	// Proximity
+	separationNm float64
+	separationFt int
+	predictAhead time.Duration
+
+	// Lifecycle
+	staleAfter time.Duration
+	lostAfter  time.Duration
+	newAfter   time.Duration
+
+	// Event bus
+	eventLog string
+
+	// Sinks rules can notify
+	sinkDefs sinkFlags
+
+	// Noise monitoring
+	noiseFile string
+
+	// Altitude
+	qnhFlag float64
+	qnhFile string
+
+	// Units of responses
+	unitsName string
 )
 
 var (
@@ -30,11 +80,51 @@ func init() {
 	flag.UintVar(&port, "p", 8888, "Port to bind output webserver.")
 	flag.BoolVar(&verbose, "v", false, "Enable verbose message logging. This will list contents of received messages.")
 	flag.BoolVar(&veryVerbose, "vv", false, "Enable very verbose message logging. This will list raw received messages. Requires verbose flag")
+	flag.StringVar(&rxName, "rx", "default", "Name of the receiver providing input. Used to keep per receiver statistics.")
+	flag.Float64Var(&rxLat, "lat", 0, "Latitude of the receiver antenna.")
+	flag.Float64Var(&rxLon, "lon", 0, "Longitude of the receiver antenna.")
+	flag.StringVar(&alertWebhook, "alert-webhook", "", "URL to POST alerts to.")
+	flag.StringVar(&alertExec, "alert-exec", "", "Command to run for each alert. The alert is passed on stdin and in TAMER_EVENT.")
+	flag.StringVar(&alertLog, "alert-log", "", "File to append alerts to.")
+	flag.DurationVar(&alertCooldown, "alert-cooldown", time.Minute*5, "Minimum time between repeats of the same alert for a plane.")
+	flag.StringVar(&fenceFiles, "fences", "", "Comma separated list of GeoJSON files containing geofence polygons.")
+	flag.StringVar(&airspaceFiles, "airspace", "", "Comma separated list of OpenAir airspace files.")
+	flag.StringVar(&vfrSquawks, "vfr-squawks", "1200,7000,2000", "Comma separated squawks which show an aircraft is not under ATC control.")
+	flag.Float64Var(&routeTolerance, "route-tolerance", 50, "Distance in nm from its expected route before an aircraft is flagged as off route.")
+	flag.StringVar(&runwayAirports, "runway-airports", "", "Comma separated airport idents to track the runways in use of.")
+	flag.Float64Var(&separationNm, "separation-nm", 1, "Horizontal distance in nm under which a pair of aircraft is an encounter.")
+	flag.IntVar(&separationFt, "separation-ft", 1000, "Vertical distance in feet under which a pair of aircraft is an encounter.")
+	flag.DurationVar(&predictAhead, "predict", time.Minute*5, "How far ahead to predict paths and conflicts.")
+	flag.DurationVar(&staleAfter, "stale", time.Second*20, "Time without a message after which a plane is stale.")
+	flag.DurationVar(&lostAfter, "lost", time.Second*40, "Time without a message after which the signal of a plane is lost. Lost planes are archived after a minute.")
+	flag.DurationVar(&newAfter, "new-after", time.Hour*24*30, "Time without being seen after which an aircraft is new again. 0 only counts the first sighting.")
+	flag.StringVar(&eventLog, "event-log", "", "File to append every aircraft event to.")
+	flag.Var(&sinkDefs, "sink", "Sink rules can notify, as name=spec where spec is webhook:<url>, exec:<command>, file:<path> or mqtt://<host>[:port]/<topic>. Can be repeated.")
+	flag.Float64Var(&qnhFlag, "qnh", 0, "Local QNH in hPa, to correct barometric altitudes. 0 if unknown.")
+	flag.StringVar(&qnhFile, "qnh-file", "", "File containing the local QNH, in hPa or inHg or as a METAR. It is read again when it changes.")
+	flag.StringVar(&unitsName, "units", "aviation", "Default units of responses: aviation (ft, kt, nm), imperial (ft, mph, nm) or metric (m, km/h, km).")
+	flag.StringVar(&noiseFile, "noise-points", "", "CSV file of noise monitoring points, with the columns name, lat, lon, radius (nm) and altitude (feet).")
+}
+
+// haveReceiver returns true if the receiver location has been configured.
+func haveReceiver() bool {
+	return rxLat != 0 || rxLon != 0
 }
 
 func main() {
 	flag.Parse()
 
+	defaultUnits = findUnits(unitsName)
+	if defaultUnits == nil {
+		fmt.Fprintf(os.Stderr, "unknown units: %q\n", unitsName)
+		os.Exit(2)
+	}
+	units = defaultUnits
+
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	msgs := make(chan *message, 50)
 	cmds := make(chan *BoardCmd)
 	sigint := make(chan os.Signal, 1)
@@ -49,8 +139,62 @@ func main() {
 		os.Exit(1)
 	}
 
+	err = loadCoverage(rxName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading receiver coverage: %v\n", err)
+	}
+
+	initAlerts()
+	initProximity()
+	initEventLog(eventLog)
+	err = loadNamedSinks(sinkDefs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading sinks: %v\n", err)
+		os.Exit(1)
+	}
+	err = loadAirlines()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading airlines: %v\n", err)
+	}
+	err = loadAirports()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading airports: %v\n", err)
+	}
+	initRunwayUse(runwayAirports)
+	err = loadWatchlist()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading watchlist: %v\n", err)
+	}
+	err = loadRules()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading rules: %v\n", err)
+	}
+	err = loadGeofences(fenceFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading geofences: %v\n", err)
+		os.Exit(1)
+	}
+	err = loadAirspace(airspaceFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading airspace: %v\n", err)
+		os.Exit(1)
+	}
+	if qnhFlag != 0 {
+		setQnh(qnhFlag, "flag", time.Now())
+	}
+	err = loadQnhFile(qnhFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading QNH: %v\n", err)
+	}
+	err = loadNoisePoints(noiseFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading noise points: %v\n", err)
+		os.Exit(1)
+	}
+
 	json := StartServer(cmds)
 	tick := time.NewTicker(savePeriod)
+	stateTick := time.NewTicker(lifecycleScan)
 
 	go connect(msgs)
 
@@ -59,17 +203,26 @@ func main() {
 		case m := <-msgs:
 			pl, _ := getPlaneByIcao(m.icao)
 			if _, ok := planeCache[m.icao]; !ok {
+				enrichPlane(pl)
 				planeCache[m.icao] = pl
+				startVisit(pl, m.dGen)
 			}
 			updatePlane(m, pl)
 		case cmd := <-cmds:
 			json <- handleCommand(cmd)
 		case t := <-tick.C:
 			saveData(t)
+			err = loadQnhFile(qnhFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error loading QNH: %v\n", err)
+			}
+		case t := <-stateTick.C:
+			checkLifecycles(t)
 		case <-sigint:
 			saveData(time.Time{})
 			err = closeDB()
 			tick.Stop()
+			stateTick.Stop()
 			close(cmds)
 			cmds = nil
 			close(json)
@@ -85,6 +238,36 @@ func main() {
 	os.Exit(0)
 }
 
+// runCommand runs one of the maintenance sub commands and returns the exit code.
+func runCommand(args []string) int {
+	err := initDB()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "errors setting up database: %v\n", err)
+		return 1
+	}
+	defer closeDB()
+
+	switch args[0] {
+	case "import-aircraft":
+		err = importAircraft(args[1:])
+	case "import-airlines":
+		err = importAirlines(args[1:])
+	case "import-airports":
+		err = importAirports(args[1:])
+	case "import-routes":
+		err = importRoutes(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %q\n", args[0])
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
 func getPlaneByIcao(icao uint) (*Plane, error) {
 	pl, ok := planeCache[icao]
 	var err error
@@ -103,15 +286,82 @@ func getPlaneByIcao(icao uint) (*Plane, error) {
 }
 
 func handleCommand(cmd *BoardCmd) string {
+	// Responses are written in the units asked for, then the default units are restored
+	// for anything written while handling messages.
+	if cmd.Units != nil {
+		units = cmd.Units
+		defer func() { units = defaultUnits }()
+	}
+
 	switch cmd.Cmd {
 	case GetCurrent:
-		return currentPlanes(cmd.Since)
+		return currentPlanes(cmd.Since, cmd.Filter)
 	case GetAll:
-		return getAllPlanes(cmd.Since)
+		return getAllPlanes(cmd.Since, cmd.Filter)
 	case GetPlane:
 		return detailedPlane(cmd.Icao)
 	case GetLocations:
 		return getPlaneLocations(cmd.Icao, cmd.Since)
+	case GetCoverage:
+		return coverageGeoJson(cmd.Arg)
+	case GetCoverageHistory:
+		return coverageHistory(cmd.Arg, cmd.Since)
+	case GetAlerts:
+		return getAlerts(cmd.Icao, cmd.Since)
+	case GetFlights:
+		return searchFlights(cmd.Arg)
+	case GetLookup:
+		return lookupRegistration(cmd.Arg, cmd.Since)
+	case GetFences:
+		return listGeofences()
+	case GetFenceEvents:
+		return getFenceEvents(cmd.Arg, cmd.Since)
+	case GetAirspaceEvents:
+		return getAirspaceEvents(cmd.Since, false)
+	case GetIncursions:
+		return getAirspaceEvents(cmd.Since, true)
+	case GetAirport:
+		return getAirport(cmd.Arg)
+	case GetMovements:
+		return getMovements(cmd.Arg, cmd.Since)
+	case GetRunways:
+		return getRunwayHistory(cmd.Arg, cmd.Since)
+	case GetRunwaysInUse:
+		return getRunwaysInUse()
+	case GetPhases:
+		return getPhaseChanges(cmd.Icao, cmd.Since)
+	case GetEvents:
+		return getFlightEvents(cmd.Icao, cmd.Filter.Get("kind"), cmd.Since)
+	case GetEncounters:
+		return getEncounters(cmd.Since)
+	case GetPrediction:
+		return getPrediction(cmd.Icao, cmd.Filter.Get("m"))
+	case GetConflicts:
+		return getConflicts()
+	case Watchlist:
+		return watchlistCommand(cmd.Method, cmd.Arg, cmd.Body)
+	case Rules:
+		return rulesCommand(cmd.Method, cmd.Arg, cmd.Body)
+	case GetNew:
+		return getNewAircraft(cmd.Since)
+	case GetLogbook:
+		return getLogbook(cmd.Since, cmd.Filter)
+	case Qnh:
+		return qnhCommand(cmd.Method, cmd.Body, time.Now())
+	case GetNoisePoints:
+		return listNoisePoints()
+	case GetOverflights:
+		return getOverflights(cmd.Arg, cmd.Since)
+	case GetNoiseDaily:
+		return getNoiseReport(cmd.Arg, "daily", cmd.Filter)
+	case GetNoiseMonthly:
+		return getNoiseReport(cmd.Arg, "monthly", cmd.Filter)
+	case GetStateChanges:
+		return getStateChanges(cmd.Since, cmd.Filter.Get("state"))
+	case GetSuspects:
+		return getSuspects()
+	case GetAnomalies:
+		return getAnomalies(cmd.Icao, cmd.Since)
 	default:
 		fmt.Fprintf(os.Stderr, "unknown board command: %v", cmd.Cmd)
 		return ""
@@ -119,6 +369,8 @@ func handleCommand(cmd *BoardCmd) string {
 }
 
 func saveData(t time.Time) {
+	saveCoverage(t)
+
 	if len(planeCache) == 0 {
 		return
 	}
@@ -131,7 +383,9 @@ func saveData(t time.Time) {
 		toSave = make([]*Plane, len(planeCache))
 		for icao, pl := range planeCache {
 			toSave[i] = pl
+			setState(pl, StateArchived, time.Now())
 			delete(planeCache, icao)
+			planeRemoved(pl, t)
 			i++
 		}
 	} else {
@@ -140,7 +394,9 @@ func saveData(t time.Time) {
 		for icao, pl := range planeCache {
 			if period.After(pl.LastSeen) {
 				toSave = append(toSave, pl)
+				setState(pl, StateArchived, t)
 				delete(planeCache, icao)
+				planeRemoved(pl, t)
 			}
 		}
 	}
@@ -158,3 +414,19 @@ func saveData(t time.Time) {
 		}
 	}
 }
+
+// planeRemoved releases any state held for a plane which is no longer active. The time is
+// zero when shutting down.
+func planeRemoved(pl *Plane, t time.Time) {
+	icao := pl.Icao
+	forgetAlerts(pl, t)
+	forgetGeofences(pl, t)
+	forgetMovements(icao)
+	forgetPhase(icao)
+	forgetBehaviour(icao)
+	forgetProximity(icao, t)
+	forgetConflicts(icao)
+	forgetRules(icao, t)
+	saveLogbook(icao, t)
+	forgetNoisePoints(icao, t)
+}
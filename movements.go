@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	MovementDeparture = "departure"
+	MovementArrival   = "arrival"
+)
+
+const (
+	// Distance in nm from an airport within which departures and arrivals are detected.
+	movementRadius = 5.0
+	// Height in feet above the airport below which a climbing or descending aircraft is
+	// taken to be departing or arriving.
+	movementHeight = 1500
+	// Vertical rate in feet per minute for an aircraft to be climbing or descending.
+	movementRate = 300
+	// Maximum difference in degrees between the track and the runway heading.
+	runwayTolerance = 20.0
+	// Time after a movement during which the same movement is not raised again.
+	movementRepeat = time.Minute * 30
+)
+
+type Movement struct {
+	id        int
+	Icao      uint
+	Time      time.Time
+	Airport   string
+	Runway    string
+	Kind      string
+	CallSign  string
+	Latitude  float32
+	Longitude float32
+	Altitude  int
+}
+
+func (m *Movement) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", m.id))
+	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", m.Icao))
+	buf.WriteString(fmt.Sprintf("\"time\": %q, ", m.Time.String()))
+	buf.WriteString(fmt.Sprintf("\"airport\": %q, ", m.Airport))
+	buf.WriteString(fmt.Sprintf("\"runway\": %q, ", m.Runway))
+	buf.WriteString(fmt.Sprintf("\"kind\": %q, ", m.Kind))
+	buf.WriteString(fmt.Sprintf("\"callsign\": %q, ", m.CallSign))
+	buf.WriteString(fmt.Sprintf("\"location\": \"%f,%f\", ", m.Latitude, m.Longitude))
+	buf.WriteString(units.altitude("altitude", m.Altitude))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+type movementState struct {
+	OnGround bool
+	Last     *Movement
+}
+
+// Ground state and latest movement of each active plane.
+var movementStates = make(map[uint]*movementState)
+
+// checkMovements detects departures and arrivals, either from a change of the on ground
+// flag or from a low aircraft climbing or descending near an airport.
+func checkMovements(pl *Plane, t time.Time) {
+	if len(airportList) == 0 {
+		return
+	}
+
+	st, ok := movementStates[pl.Icao]
+	if !ok {
+		movementStates[pl.Icao] = &movementState{OnGround: pl.OnGround}
+		return
+	}
+	if len(pl.Locations) == 0 {
+		st.OnGround = pl.OnGround
+		return
+	}
+
+	l := pl.Locations[len(pl.Locations)-1]
+	lat, lon := float64(l.Latitude), float64(l.Longitude)
+
+	if pl.OnGround != st.OnGround {
+		st.OnGround = pl.OnGround
+		kind := MovementDeparture
+		if pl.OnGround {
+			kind = MovementArrival
+		}
+		// Prefer an airport with a runway lined up with the track, else the nearest.
+		nearby := nearbyAirports(lat, lon)
+		for _, a := range nearby {
+			if rw := findRunway(a, lat, lon, pl); rw != "" {
+				raiseMovement(st, pl, l, a, rw, kind)
+				return
+			}
+		}
+		if len(nearby) > 0 {
+			raiseMovement(st, pl, l, nearby[0], "", kind)
+		}
+		return
+	}
+
+	// Without a change of ground state, only use fresh positions of low aircraft which
+	// are lined up with a runway.
+	if pl.OnGround || !l.Time.Equal(t) || pl.Altitude == 0 {
+		return
+	}
+
+	var kind string
+	if pl.Vertical >= movementRate {
+		kind = MovementDeparture
+	} else if pl.Vertical <= -movementRate {
+		kind = MovementArrival
+	} else {
+		return
+	}
+
+	for _, a := range nearbyAirports(lat, lon) {
+		if pl.Altitude-a.Elevation > movementHeight {
+			continue
+		}
+		if rw := findRunway(a, lat, lon, pl); rw != "" || len(a.Runways) == 0 {
+			raiseMovement(st, pl, l, a, rw, kind)
+			return
+		}
+	}
+}
+
+// findRunway returns the ident of the runway end at the airport which the plane's track
+// is lined up with, nearest the extended centreline. Returns an empty string if there is none.
+func findRunway(a *Airport, lat, lon float64, pl *Plane) string {
+	if pl.Speed == 0 && pl.Track == 0 {
+		return ""
+	}
+
+	p := point{Lat: lat, Lon: lon}
+	var best string
+	bestScore := -1.0
+	for _, r := range a.Runways {
+		for i, e := range r.Ends {
+			if e.Ident == "" || e.Heading < 0 {
+				continue
+			}
+			diff := headingDiff(float64(pl.Track), e.Heading)
+			if diff > runwayTolerance {
+				continue
+			}
+			// Without thresholds fall back to how closely the heading matches.
+			score := diff / 100
+			if r.HasLocation() {
+				o := r.Ends[1-i]
+				score = crossTrackNm(p, point{Lat: e.Latitude, Lon: e.Longitude}, point{Lat: o.Latitude, Lon: o.Longitude})
+			}
+			if bestScore < 0 || score < bestScore {
+				bestScore = score
+				best = e.Ident
+			}
+		}
+	}
+
+	return best
+}
+
+func raiseMovement(st *movementState, pl *Plane, l Location, a *Airport, runway string, kind string) {
+	if st.Last != nil && st.Last.Kind == kind && st.Last.Airport == a.Ident && l.Time.Sub(st.Last.Time) < movementRepeat {
+		return
+	}
+
+	m := &Movement{Icao: pl.Icao, Time: l.Time, Airport: a.Ident, Runway: runway, Kind: kind, CallSign: pl.CallSign,
+		Latitude: l.Latitude, Longitude: l.Longitude, Altitude: pl.Altitude}
+	st.Last = m
+	updateRunwayUse(m)
+
+	if verbose {
+		fmt.Printf("Movement: %06X (%s) %s %s runway %q\n", m.Icao, m.CallSign, m.Kind, m.Airport, m.Runway)
+	}
+	go func() {
+		err := SaveMovement(m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving movement: %v\n", err)
+		}
+	}()
+}
+
+// forgetMovements removes the movement state of a plane which is no longer active.
+func forgetMovements(icao uint) {
+	delete(movementStates, icao)
+}
+
+// getMovements returns the departures and arrivals board for an airport.
+func getMovements(ident string, t time.Time) string {
+	ident = strings.ToUpper(ident)
+	movements, err := LoadMovements(ident, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading movements: %v\n", err)
+	}
+
+	var deps, arrs []string
+	for _, m := range movements {
+		if m.Kind == MovementDeparture {
+			deps = append(deps, m.ToJson())
+		} else {
+			arrs = append(arrs, m.ToJson())
+		}
+	}
+
+	airport := "null"
+	if a, ok := airports[ident]; ok {
+		airport = a.ToJson()
+	}
+
+	return fmt.Sprintf("{\"airport\": %s, \"departures\": [%s], \"arrivals\": [%s]}",
+		airport, strings.Join(deps, ",\n"), strings.Join(arrs, ",\n"))
+}
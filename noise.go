@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NoisePoint is a noise monitoring point. Aircraft inside the cylinder of the radius and up
+// to the altitude overfly it.
+type NoisePoint struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+	Radius    float64 // nm
+	Altitude  int     // feet
+}
+
+func (p *NoisePoint) ToJson() string {
+	return fmt.Sprintf("{\"name\": %q, \"location\": \"%f,%f\", %s, %s}",
+		p.Name, p.Latitude, p.Longitude, units.distance("radius", p.Radius), units.altitude("altitude", p.Altitude))
+}
+
+// Overflight is an aircraft passing over a noise monitoring point. The time, distance and
+// altitude are at its closest approach.
+type Overflight struct {
+	id       int
+	Point    string
+	Icao     uint
+	CallSign string
+	Type     string
+	Start    time.Time
+	End      time.Time
+	Time     time.Time
+	Distance float64 // nm
+	Altitude int     // feet
+}
+
+func (o *Overflight) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", o.id))
+	buf.WriteString(fmt.Sprintf("\"point\": %q, ", o.Point))
+	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", o.Icao))
+	buf.WriteString(fmt.Sprintf("\"callsign\": %q, ", o.CallSign))
+	buf.WriteString(fmt.Sprintf("\"type\": %q, ", o.Type))
+	buf.WriteString(fmt.Sprintf("\"start\": %q, ", o.Start.String()))
+	buf.WriteString(fmt.Sprintf("\"end\": %q, ", o.End.String()))
+	buf.WriteString(fmt.Sprintf("\"time\": %q, ", o.Time.String()))
+	buf.WriteString(units.distance("distance", o.Distance) + ", ")
+	buf.WriteString(units.altitude("altitude", o.Altitude))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+// overflightCsvHeader returns the names of the CSV columns, with the units of the values.
+func overflightCsvHeader() string {
+	return fmt.Sprintf("point,icao,callsign,type,time,distance_%s,altitude_%s,start,end", csvUnit(units.Distance), csvUnit(units.Altitude))
+}
+
+func (o *Overflight) ToCsv() string {
+	return fmt.Sprintf("%s,%06X,%s,%s,%s,%s,%s,%s,%s", o.Point, o.Icao, o.CallSign, o.Type, o.Time.UTC().Format(time.RFC3339),
+		units.distanceValue(o.Distance), units.altitudeValue(o.Altitude), o.Start.UTC().Format(time.RFC3339), o.End.UTC().Format(time.RFC3339))
+}
+
+// Layouts of the day and month of a noise report.
+const (
+	noiseDateLayout  = "2006-01-02"
+	noiseMonthLayout = "2006-01"
+)
+
+var (
+	noisePoints []*NoisePoint
+	// Overflights in progress of each plane, keyed by point name.
+	overflights = make(map[uint]map[string]*Overflight)
+)
+
+// loadNoisePoints reads the monitoring points from a CSV file with the columns name, lat,
+// lon, radius (nm) and altitude (feet).
+func loadNoisePoints(file string) error {
+	if file == "" {
+		return nil
+	}
+
+	return readCsvFile(file, func(fields []string, h map[string]int) error {
+		p := &NoisePoint{Name: csvField(fields, h, "NAME")}
+		var err error
+		p.Latitude, err = strconv.ParseFloat(csvField(fields, h, "LAT"), 64)
+		if err == nil {
+			p.Longitude, err = strconv.ParseFloat(csvField(fields, h, "LON"), 64)
+		}
+		if err == nil {
+			p.Radius, err = strconv.ParseFloat(csvField(fields, h, "RADIUS"), 64)
+		}
+		if err == nil {
+			p.Altitude, err = strconv.Atoi(csvField(fields, h, "ALTITUDE"))
+		}
+		if err != nil || p.Name == "" {
+			return errors.New(fmt.Sprintf("invalid noise point %q in %q", strings.Join(fields, ","), file))
+		}
+		noisePoints = append(noisePoints, p)
+		return nil
+	})
+}
+
+// checkNoisePoints starts, updates and ends the plane's overflights of the monitoring points.
+func checkNoisePoints(pl *Plane, t time.Time) {
+	if len(noisePoints) == 0 || len(pl.Locations) == 0 {
+		return
+	}
+	l := pl.Locations[len(pl.Locations)-1]
+
+	for _, p := range noisePoints {
+		d := distanceNm(p.Latitude, p.Longitude, float64(l.Latitude), float64(l.Longitude))
+		inside := d <= p.Radius && pl.Altitude <= p.Altitude && (pl.Altitude > 0 || pl.OnGround)
+
+		o := overflights[pl.Icao][p.Name]
+		if !inside {
+			if o != nil {
+				endOverflight(pl.Icao, o, t)
+			}
+			continue
+		}
+
+		if o == nil {
+			o = &Overflight{Point: p.Name, Icao: pl.Icao, Start: t, Distance: math.MaxFloat64}
+			if overflights[pl.Icao] == nil {
+				overflights[pl.Icao] = make(map[string]*Overflight)
+			}
+			overflights[pl.Icao][p.Name] = o
+		}
+		o.CallSign, o.Type, o.End = pl.CallSign, pl.Info.Type, t
+		if d < o.Distance {
+			o.Time, o.Distance, o.Altitude = t, d, pl.Altitude
+		}
+	}
+}
+
+func endOverflight(icao uint, o *Overflight, t time.Time) {
+	delete(overflights[icao], o.Point)
+	if len(overflights[icao]) == 0 {
+		delete(overflights, icao)
+	}
+
+	if verbose {
+		fmt.Printf("Overflight: %06X (%s) over %s, closest %.2fnm at %dft\n", o.Icao, o.CallSign, o.Point, o.Distance, o.Altitude)
+	}
+	save := func() {
+		err := SaveOverflight(o)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving overflight: %v\n", err)
+		}
+	}
+	if t.IsZero() {
+		save()
+	} else {
+		go save()
+	}
+}
+
+// forgetNoisePoints ends the overflights of a plane which is no longer active.
+func forgetNoisePoints(icao uint, t time.Time) {
+	for _, o := range overflights[icao] {
+		endOverflight(icao, o, t)
+	}
+}
+
+func findNoisePoint(name string) *NoisePoint {
+	for _, p := range noisePoints {
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+func listNoisePoints() string {
+	sl := make([]string, len(noisePoints))
+	for i, p := range noisePoints {
+		n := 0
+		for _, of := range overflights {
+			if _, ok := of[p.Name]; ok {
+				n++
+			}
+		}
+		sl[i] = fmt.Sprintf("{\"point\": %s, \"overflying\": %d}", p.ToJson(), n)
+	}
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
+
+func getOverflights(name string, t time.Time) string {
+	p := findNoisePoint(name)
+	if p == nil {
+		return "null"
+	}
+
+	ofs, err := LoadOverflights(p.Name, t, time.Time{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading overflights: %v\n", err)
+		return "[]"
+	}
+
+	sl := make([]string, len(ofs))
+	for i, o := range ofs {
+		sl[i] = o.ToJson()
+	}
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
+
+// getNoiseReport returns the overflights of the point for a day (date=YYYY-MM-DD) or month
+// (month=YYYY-MM), today or this month by default. The server rejects malformed periods. The
+// JSON report has the counts for each hour of the day or day of the month. With format=csv
+// it is a list of the overflights.
+func getNoiseReport(name, period string, filter url.Values) string {
+	p := findNoisePoint(name)
+	if p == nil {
+		return "null"
+	}
+
+	now := time.Now()
+	var start, end time.Time
+	var buckets int
+	var bucket func(t time.Time) int
+	if period == "monthly" {
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+		if m := filter.Get("month"); m != "" {
+			if t, err := time.ParseInLocation(noiseMonthLayout, m, time.Local); err == nil {
+				start = t
+			}
+		}
+		end = start.AddDate(0, 1, 0)
+		buckets = end.AddDate(0, 0, -1).Day()
+		bucket = func(t time.Time) int { return t.Day() - 1 }
+	} else {
+		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+		if d := filter.Get("date"); d != "" {
+			if t, err := time.ParseInLocation(noiseDateLayout, d, time.Local); err == nil {
+				start = t
+			}
+		}
+		end = start.AddDate(0, 0, 1)
+		buckets = 24
+		bucket = func(t time.Time) int { return t.Hour() }
+	}
+
+	ofs, err := LoadOverflights(p.Name, start, end)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading overflights: %v\n", err)
+	}
+
+	if filter.Get("format") == "csv" {
+		lines := []string{overflightCsvHeader()}
+		for _, o := range ofs {
+			lines = append(lines, o.ToCsv())
+		}
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	counts := make([]int, buckets)
+	types := make(map[string]int)
+	var totalAlt int
+	lowest, closest := -1, -1.0
+	sl := make([]string, len(ofs))
+	for i, o := range ofs {
+		if b := bucket(o.Time.In(time.Local)); b >= 0 && b < buckets {
+			counts[b]++
+		}
+		types[o.Type]++
+		totalAlt += o.Altitude
+		if lowest < 0 || o.Altitude < lowest {
+			lowest = o.Altitude
+		}
+		if closest < 0 || o.Distance < closest {
+			closest = o.Distance
+		}
+		sl[i] = o.ToJson()
+	}
+
+	var tl []string
+	for t := range types {
+		tl = append(tl, t)
+	}
+	sort.Slice(tl, func(i, j int) bool {
+		return types[tl[i]] > types[tl[j]] || (types[tl[i]] == types[tl[j]] && tl[i] < tl[j])
+	})
+	tc := make([]string, len(tl))
+	for i, t := range tl {
+		tc[i] = fmt.Sprintf("%q: %d", t, types[t])
+	}
+
+	cs := make([]string, len(counts))
+	for i, c := range counts {
+		cs[i] = strconv.Itoa(c)
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"point\": %s, ", p.ToJson()))
+	buf.WriteString(fmt.Sprintf("\"period\": %q, ", period))
+	buf.WriteString(fmt.Sprintf("\"start\": %q, ", start.String()))
+	buf.WriteString(fmt.Sprintf("\"end\": %q, ", end.String()))
+	buf.WriteString(fmt.Sprintf("\"count\": %d, ", len(ofs)))
+	if len(ofs) > 0 {
+		buf.WriteString(fmt.Sprintf("%s, %s, %s, ", units.altitude("lowest", lowest), units.distance("closest", closest),
+			units.altitude("averageAltitude", totalAlt/len(ofs))))
+	}
+	buf.WriteString(fmt.Sprintf("\"counts\": [%s], ", strings.Join(cs, ", ")))
+	buf.WriteString(fmt.Sprintf("\"types\": {%s}, ", strings.Join(tc, ", ")))
+	buf.WriteString(fmt.Sprintf("\"overflights\": [%s]", strings.Join(sl, ",\n")))
+	buf.WriteString("}")
+
+	return buf.String()
+}
@@ -37,6 +37,9 @@ const (
 	emergency    // Flag to indicate Emergency
 	identActive  // Flag to indicate transponder Ident has been activated
 	onGround     // Flag to indicate ground squawk switch is active
+
+	// Extension, only sent by some feeds
+	geoAltitude // Geometric (GNSS) altitude
 )
 
 type message struct {
@@ -56,6 +59,7 @@ type message struct {
 	emergency   bool
 	ident       bool
 	onGround    bool
+	geoAlt      int
 }
 
 func connect(out chan<- *message) {
@@ -89,7 +93,7 @@ func connect(out chan<- *message) {
 
 func parseMessage(m []byte, out chan<- *message) {
 	parts := bytes.Split(m, []byte{','})
-	if len(parts) != 22 {
+	if len(parts) != 22 && len(parts) != 23 {
 		if verbose {
 			fmt.Fprintf(os.Stderr, "Discarding bad message: %q\n", m)
 		}
@@ -230,5 +234,9 @@ func parseMsgType(msg [][]byte, tt int) (*message, error) {
 		m.onGround = parseBool(msg[onGround])
 	}
 
+	if len(msg) > geoAltitude {
+		m.geoAlt = parseInt(bytes.TrimSpace(msg[geoAltitude]))
+	}
+
 	return m, nil
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	PhaseTaxi     = "taxi"
+	PhaseTakeoff  = "takeoff"
+	PhaseClimb    = "climb"
+	PhaseCruise   = "cruise"
+	PhaseDescent  = "descent"
+	PhaseApproach = "approach"
+	PhaseLanding  = "landing"
+)
+
+const (
+	// Ground speed in knots above which an aircraft on the ground is taking off or landing.
+	taxiSpeed = 40
+	// Vertical rate in feet per minute to start climbing or descending, and the rate which
+	// keeps an aircraft climbing or descending once it is.
+	phaseRate     = 500
+	phaseHoldRate = 200
+	// Height in feet above the airport below which a climb is still the takeoff.
+	takeoffHeight = 1500
+	// Height in feet above the airport below which a descent is the approach.
+	approachHeight = 3000
+	// Height in feet above the airport below which a descent on the approach is the landing.
+	landingHeight = 200
+	// Time a new phase must be seen for before the plane changes to it.
+	phaseHold = time.Second * 15
+)
+
+type PhaseChange struct {
+	id       int
+	Icao     uint
+	Time     time.Time
+	From     string
+	To       string
+	Altitude int
+	Speed    float32
+}
+
+func (c *PhaseChange) ToJson() string {
+	return fmt.Sprintf("{\"id\": %d, \"icao\": \"%06X\", \"time\": %q, \"from\": %q, \"to\": %q, %s, %s}",
+		c.id, c.Icao, c.Time.String(), c.From, c.To, units.altitude("altitude", c.Altitude), units.speed("speed", float64(c.Speed)))
+}
+
+type phaseState struct {
+	Candidate string
+	Since     time.Time
+}
+
+// Phase each plane may be changing to, and when it was first seen.
+var phaseStates = make(map[uint]*phaseState)
+
+// classifyPhase returns the phase of flight the plane's current state suggests. Heights
+// are above the nearest airport if one is in range, else above sea level.
+func classifyPhase(pl *Plane) string {
+	if pl.OnGround {
+		if pl.Speed < taxiSpeed {
+			return PhaseTaxi
+		}
+		switch pl.Phase {
+		case PhaseApproach, PhaseLanding, PhaseDescent:
+			return PhaseLanding
+		}
+		return PhaseTakeoff
+	}
+
+	if pl.Altitude == 0 {
+		return pl.Phase
+	}
+
+	height := pl.Altitude
+	if len(pl.Locations) > 0 {
+		l := pl.Locations[len(pl.Locations)-1]
+		if nearby := nearbyAirports(float64(l.Latitude), float64(l.Longitude)); len(nearby) > 0 {
+			height -= nearby[0].Elevation
+		}
+	}
+
+	climbing := pl.Vertical >= phaseRate
+	descending := pl.Vertical <= -phaseRate
+	switch pl.Phase {
+	case PhaseTakeoff, PhaseClimb:
+		climbing = pl.Vertical > phaseHoldRate
+	case PhaseDescent, PhaseApproach, PhaseLanding:
+		descending = pl.Vertical < -phaseHoldRate
+	}
+
+	switch {
+	case climbing && height < takeoffHeight && (pl.Phase == "" || pl.Phase == PhaseTaxi || pl.Phase == PhaseTakeoff):
+		return PhaseTakeoff
+	case climbing:
+		return PhaseClimb
+	case descending && height < landingHeight && (pl.Phase == PhaseApproach || pl.Phase == PhaseLanding):
+		return PhaseLanding
+	case descending && height < approachHeight:
+		return PhaseApproach
+	case descending:
+		return PhaseDescent
+	case pl.Phase == PhaseApproach && height < approachHeight:
+		// Level segments of an approach.
+		return PhaseApproach
+	default:
+		return PhaseCruise
+	}
+}
+
+// checkPhase updates the plane's phase of flight. A new phase must be seen for the hold
+// time before the plane changes to it, so that it doesn't flap between phases.
+func checkPhase(pl *Plane, t time.Time) {
+	phase := classifyPhase(pl)
+	if phase == pl.Phase {
+		delete(phaseStates, pl.Icao)
+		return
+	}
+
+	st, ok := phaseStates[pl.Icao]
+	if !ok || st.Candidate != phase {
+		phaseStates[pl.Icao] = &phaseState{Candidate: phase, Since: t}
+		return
+	}
+	if t.Sub(st.Since) < phaseHold {
+		return
+	}
+	delete(phaseStates, pl.Icao)
+
+	c := &PhaseChange{Icao: pl.Icao, Time: t, From: pl.Phase, To: phase, Altitude: pl.Altitude, Speed: pl.Speed}
+	pl.Phase = phase
+	pl.PhaseTime = t
+
+	if verbose {
+		fmt.Printf("Phase: %06X (%s) %s -> %s\n", c.Icao, pl.CallSign, c.From, c.To)
+	}
+	go func() {
+		err := SavePhaseChange(c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving phase change: %v\n", err)
+		}
+	}()
+}
+
+// forgetPhase removes the pending phase of a plane which is no longer active.
+func forgetPhase(icao uint) {
+	delete(phaseStates, icao)
+}
+
+func getPhaseChanges(icao uint, t time.Time) string {
+	changes, err := LoadPhaseChanges(icao, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading phase changes: %v\n", err)
+		return "[]"
+	}
+
+	sl := make([]string, len(changes))
+	for i, c := range changes {
+		sl[i] = c.ToJson()
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
@@ -13,6 +13,7 @@ type Location struct {
 	Time      time.Time
 	Latitude  float32
 	Longitude float32
+	Altitude  int
 }
 
 type ValuePair struct {
@@ -24,14 +25,34 @@ type ValuePair struct {
 // Don't add that to the history. However add new changes. Always update LastSeen if after
 type Plane struct {
 	Icao      uint
+	Info      AircraftInfo
+	Country   string
+	Flag      string
+	Military  bool
 	CallSign  string
 	CallSigns []ValuePair
+	Route     *Route // expected route for the current callsign
+	Squawk    string
 	Squawks   []ValuePair
 	Locations []Location
-	Altitude  int
+	Altitude  int // barometric (pressure) altitude in feet
+	GeoAlt    int // geometric (GNSS) altitude in feet, 0 if not reported
 	Track     float32
 	Speed     float32
 	Vertical  int
+	Velocity  bool // a velocity has been reported, so the track and vertical rate are known
+	Airspace  []string
+	Phase     string         // phase of flight
+	PhaseTime time.Time      // time the phase started
+	Anomaly   int            // anomaly score from 0 to 100
+	Anomalies map[string]int // count of each kind of anomaly seen
+	Watched   *WatchEntry    // watchlist entry the plane matches
+	State     string         // lifecycle state while active
+	StateTime time.Time      // time the state started
+	FirstSeen time.Time      // first time the plane was ever seen
+	Visits    int            // number of times the plane has become active
+	Visit     time.Time      // start of the current visit
+	TotalTime time.Duration  // time observed over the previous visits
 	LastSeen  time.Time
 	History   []*message // won't contain duplicate messages such as "on ground" unless they change
 	// Various flags
@@ -39,13 +60,33 @@ type Plane struct {
 	Emergency bool
 	Ident     bool
 	OnGround  bool
+	OffRoute  bool
 }
 
 func (p *Plane) ToJson() string {
 	buf := bytes.Buffer{}
 	buf.WriteString("{")
 	buf.WriteString(fmt.Sprintf("\"icao\": \"%06X\", ", p.Icao))
+	buf.WriteString(fmt.Sprintf("\"registration\": %q, ", p.Info.Registration))
+	buf.WriteString(fmt.Sprintf("\"manufacturer\": %q, ", p.Info.Manufacturer))
+	buf.WriteString(fmt.Sprintf("\"type\": %q, ", p.Info.Type))
+	buf.WriteString(fmt.Sprintf("\"model\": %q, ", p.Info.Model))
+	buf.WriteString(fmt.Sprintf("\"operator\": %q, ", p.Info.Operator))
+	buf.WriteString(fmt.Sprintf("\"year\": %q, ", p.Info.Year))
+	buf.WriteString(fmt.Sprintf("\"country\": %q, ", p.Country))
+	buf.WriteString(fmt.Sprintf("\"flag\": %q, ", p.Flag))
+	buf.WriteString(fmt.Sprintf("\"military\": %v, ", p.Military))
 	buf.WriteString(fmt.Sprintf("\"callsign\": %q, ", p.CallSign))
+	if f := decodeCallsign(p.CallSign); f != nil {
+		buf.WriteString(fmt.Sprintf("\"flight\": %s, ", f.ToJson()))
+	} else {
+		buf.WriteString("\"flight\": null, ")
+	}
+	if p.Route != nil {
+		buf.WriteString(fmt.Sprintf("\"route\": %s, ", p.Route.ToJson(p.OffRoute)))
+	} else {
+		buf.WriteString("\"route\": null, ")
+	}
 	buf.WriteString("\"callsigns\": [")
 	for i, cs := range p.CallSigns {
 		buf.WriteString(fmt.Sprintf("%q", cs.value))
@@ -66,10 +107,51 @@ func (p *Plane) ToJson() string {
 			buf.WriteString(", ")
 		}
 	}
-	buf.WriteString(fmt.Sprintf("], \"altitude\": %d, ", p.Altitude))
+	buf.WriteString(fmt.Sprintf("], \"squawk\": %q, ", p.Squawk))
+	buf.WriteString(fmt.Sprintf("\"emergency\": %v, ", p.Emergency))
+	buf.WriteString(units.altitude("altitude", p.Altitude) + ", ")
+	if p.GeoAlt != 0 {
+		buf.WriteString(units.altitude("geometricAltitude", p.GeoAlt) + ", ")
+	} else {
+		buf.WriteString("\"geometricAltitude\": null, ")
+	}
+	if a, ok := correctedAltitude(p); ok {
+		buf.WriteString(units.altitude("correctedAltitude", a) + ", ")
+	} else {
+		buf.WriteString("\"correctedAltitude\": null, ")
+	}
 	buf.WriteString(fmt.Sprintf("\"track\": %.2f, ", p.Track))
-	buf.WriteString(fmt.Sprintf("\"speed\": %.2f, ", p.Speed))
-	buf.WriteString(fmt.Sprintf("\"vertical\": %d, ", p.Vertical))
+	buf.WriteString(units.speed("speed", float64(p.Speed)) + ", ")
+	buf.WriteString(units.vertical("vertical", p.Vertical) + ", ")
+	buf.WriteString(fmt.Sprintf("\"phase\": %q, ", p.Phase))
+	if p.Phase != "" {
+		buf.WriteString(fmt.Sprintf("\"phaseSince\": %q, ", p.PhaseTime.String()))
+	}
+	buf.WriteString(fmt.Sprintf("\"anomalyScore\": %d, ", p.Anomaly))
+	if p.Watched != nil {
+		buf.WriteString(fmt.Sprintf("\"watch\": %s, ", p.Watched.ToJson()))
+	} else {
+		buf.WriteString("\"watch\": null, ")
+	}
+	if p.State != "" {
+		buf.WriteString(fmt.Sprintf("\"state\": %q, \"stateSince\": %q, ", p.State, p.StateTime.String()))
+	} else {
+		// Planes which aren't active have been archived.
+		buf.WriteString(fmt.Sprintf("\"state\": %q, ", StateArchived))
+	}
+	buf.WriteString("\"airspace\": [")
+	for i, a := range p.Airspace {
+		buf.WriteString(fmt.Sprintf("%q", a))
+		if i != len(p.Airspace) - 1 {
+			buf.WriteString(", ")
+		}
+	}
+	buf.WriteString("], ")
+	if !p.FirstSeen.IsZero() {
+		buf.WriteString(fmt.Sprintf("\"firstSeen\": %q, ", p.FirstSeen.String()))
+	}
+	buf.WriteString(fmt.Sprintf("\"visits\": %d, ", p.Visits))
+	buf.WriteString(fmt.Sprintf("\"totalTime\": %.0f, ", totalTime(p).Seconds()))
 	buf.WriteString(fmt.Sprintf("\"lastSeen\": %q", p.LastSeen.String()))
 	buf.WriteString("}")
 
@@ -97,16 +179,17 @@ func (p *Plane) SetCallSign(cs string) bool {
 }
 
 // SetSquawk tries to add the Squawk code to the slice of Squawks for the Plane.
-// Returns true if it was added, false if the Squawk was already in the slice.
+// Returns true if it was added, false if the Squawk is already the current squawk.
 func (p *Plane) SetSquawk(s string) bool {
 	// Search backwards as more likely to find result at end.
-	if s == "" {
+	if s == "" || p.Squawk == s {
 		return false
 	}
+	p.Squawk = s
 
 	for i := len(p.Squawks) - 1; i >= 0; i-- {
 		if s == p.Squawks[i].value {
-			return false
+			return true // True because at the least the current squawk has been set.
 		}
 	}
 
@@ -114,13 +197,13 @@ func (p *Plane) SetSquawk(s string) bool {
 	return true
 }
 
-// SetLocation creates a location from the specified Lat/lon and time and appends it
-// to the locations slice. Returns true if successful, and false if there are no values to add
+// SetLocation creates a location from the specified Lat/lon and time, at the current altitude,
+// and appends it to the locations slice. Returns true if successful, and false if there are no values to add
 func (p *Plane) SetLocation(lat, lon float32, t time.Time) bool {
 	if lat == 0.0 || lon == 0.0 {
 		return false
 	}
-	l := Location{Time: t, Latitude: lat, Longitude: lon}
+	l := Location{Time: t, Latitude: lat, Longitude: lon, Altitude: p.Altitude}
 	p.Locations = append(p.Locations, l)
 	return true
 }
@@ -135,6 +218,16 @@ func (p *Plane) SetAltitude(a int) bool {
 	return false
 }
 
+// SetGeoAlt will update the geometric altitude if different from existing altitude.
+// Returns true if successful, false if there is no change.
+func (p *Plane) SetGeoAlt(a int) bool {
+	if a != 0 && p.GeoAlt != a {
+		p.GeoAlt = a
+		return true
+	}
+	return false
+}
+
 // SetTrack sets the Plane's current track if different from existing value.
 // Returns true if successful, and false if there is no change.
 func (p *Plane) SetTrack(t float32) bool {
@@ -220,6 +313,8 @@ func updatePlane(m *message, pl *Plane) {
 	if m.dGen.After(pl.LastSeen) {
 		pl.LastSeen = m.dGen
 	}
+	planeSeen(pl, m.dGen)
+	before := fieldsOf(pl)
 
 	if verbose {
 		buf.WriteString(fmt.Sprintf("%s - %06X -", m.dGen.String(), m.icao))
@@ -227,25 +322,32 @@ func updatePlane(m *message, pl *Plane) {
 
 	var dataStr string
 	var written bool
+	var moved bool
 	switch m.tType {
 	case 1:
+		prev := pl.CallSign
 		written = pl.SetCallSign(m.callSign)
+		if written {
+			updateRoute(pl)
+			checkCallsignChange(pl, prev, m.dGen)
+		}
 		if verbose {
 			dataStr = fmt.Sprintf(" Callsign: %q", m.callSign)
 		}
 	case 2:
+		pl.Velocity = true
 		written = pl.SetAltitude(m.altitude) || written
 		written = pl.SetSpeed(m.groundSpeed) || written
 		written = pl.SetTrack(m.track) || written
-		written = pl.SetLocation(m.latitude, m.longitude, m.dGen) || written
-		written = pl.SetOnGround(m.onGround) || written
+		moved = pl.SetLocation(m.latitude, m.longitude, m.dGen)
+		written = pl.SetOnGround(m.onGround) || moved || written
 		if verbose {
 			dataStr = fmt.Sprintf(" Altitude: %d, Speed: %.2f, Track: %.2f, Lat: %s, Lon: %s", m.altitude, m.groundSpeed, m.track, m.latitude, m.longitude)
 		}
 	case 3:
 		written = pl.SetAltitude(m.altitude) || written
-		written = pl.SetLocation(m.latitude, m.longitude, m.dGen) || written
-		written = pl.SetSquawkCh(m.squawkCh) || written
+		moved = pl.SetLocation(m.latitude, m.longitude, m.dGen)
+		written = pl.SetSquawkCh(m.squawkCh) || moved || written
 		written = pl.SetEmergency(m.emergency) || written
 		written = pl.SetIdent(m.ident) || written
 		written = pl.SetOnGround(m.onGround) || written
@@ -253,9 +355,11 @@ func updatePlane(m *message, pl *Plane) {
 			dataStr = fmt.Sprintf(" Altitude: %d, Lat: %f, Lon: %f", m.altitude, m.latitude, m.longitude)
 		}
 	case 4:
+		pl.Velocity = true
 		written = pl.SetSpeed(m.groundSpeed) || written
 		written = pl.SetTrack(m.track) || written
 		written = pl.SetVertical(m.vertical) || written
+		checkVerticalRate(pl, m.dGen)
 		if verbose {
 			dataStr = fmt.Sprintf(" Speed: %.2f, Track: %.2f, Vertical Rate: %d", m.groundSpeed, m.track, m.vertical)
 		}
@@ -289,6 +393,27 @@ func updatePlane(m *message, pl *Plane) {
 			dataStr = fmt.Sprintf(" OnGround: %v", m.onGround)
 		}
 	}
+	written = pl.SetGeoAlt(m.geoAlt) || written
+
+	publishChanges(before, pl, m.dGen)
+	checkWatchlist(pl, m.dGen)
+	checkRules(pl, m.dGen)
+	updateLogbook(pl, moved, m.dGen)
+	if moved {
+		publishPosition(pl, m.dGen)
+		updateCoverage(pl)
+		checkGeofences(pl)
+		checkAirspace(pl)
+		checkRoute(pl)
+		checkBehaviour(pl, m.dGen)
+		checkProximity(pl, m.dGen)
+		checkConflicts(m.dGen)
+		checkAnomalies(pl, m.dGen)
+		checkNoisePoints(pl, m.dGen)
+	}
+	checkAlerts(pl, m.dGen)
+	checkMovements(pl, m.dGen)
+	checkPhase(pl, m.dGen)
 
 	// Log message if it updated a value, or the last message was more than 10 minutes ago
 	if written || m.dGen.Sub(pl.LastSeen) > FreshPeriod {
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Period of locations the track and turn rate are fitted to.
+	predictFitWindow = time.Second * 60
+	// Time between predicted points.
+	predictStep = time.Second * 10
+	// Uncertainty of the predicted position in nm at the start, and its growth per minute.
+	predictBaseRadius = 0.1
+	predictGrowth     = 0.3
+	// Highest turn rate in degrees per second used for predictions, a rate two turn.
+	maxTurnRate = 6.0
+	// Oldest position a plane may have to be predicted.
+	predictMaxAge = time.Minute
+	// How often predicted conflicts between all planes are looked for.
+	conflictScan = time.Second * 15
+)
+
+// PredictedPoint is a predicted position with the radius in nm it is expected to be within.
+type PredictedPoint struct {
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+	Altitude  int
+	Radius    float64
+}
+
+func (p PredictedPoint) ToJson() string {
+	return fmt.Sprintf("{\"time\": %q, \"location\": \"%f,%f\", %s, %s}",
+		p.Time.String(), p.Latitude, p.Longitude, units.altitude("altitude", p.Altitude), units.distance("radius", p.Radius))
+}
+
+// trackFit is the motion of a plane fitted from its recent locations.
+type trackFit struct {
+	From     Location
+	Speed    float64 // knots
+	Track    float64 // degrees
+	TurnRate float64 // degrees per second, positive to the right
+	Vertical int     // feet per minute
+}
+
+// fitTrack works out the plane's speed, track and turn rate from its recent locations,
+// preferring the reported speed and track when there are any. Returns nil if there are
+// too few recent locations.
+func fitTrack(pl *Plane, t time.Time) *trackFit {
+	if len(pl.Locations) == 0 {
+		return nil
+	}
+	last := pl.Locations[len(pl.Locations)-1]
+	if t.Sub(last.Time) > predictMaxAge {
+		return nil
+	}
+
+	var pts []Location
+	for _, l := range pl.Locations {
+		if !l.Time.Before(last.Time.Add(-predictFitWindow)) {
+			pts = append(pts, l)
+		}
+	}
+	sort.Slice(pts, func(i, j int) bool { return pts[i].Time.Before(pts[j].Time) })
+
+	// Thin the locations out so that small position errors don't look like turns.
+	var thin []Location
+	for _, l := range pts {
+		if len(thin) > 0 {
+			p := thin[len(thin)-1]
+			if distanceNm(float64(p.Latitude), float64(p.Longitude), float64(l.Latitude), float64(l.Longitude)) < turnStep {
+				continue
+			}
+		}
+		thin = append(thin, l)
+	}
+
+	f := &trackFit{From: pts[len(pts)-1], Speed: float64(pl.Speed), Track: float64(pl.Track), Vertical: pl.Vertical}
+	f.From.Altitude = pl.Altitude
+	if len(thin) < 2 {
+		if f.Speed == 0 {
+			return nil
+		}
+		return f
+	}
+
+	first, end := thin[0], thin[len(thin)-1]
+	if f.Speed == 0 {
+		dt := end.Time.Sub(first.Time).Hours()
+		if dt <= 0 {
+			return nil
+		}
+		var dist float64
+		for i := 1; i < len(thin); i++ {
+			dist += distanceNm(float64(thin[i-1].Latitude), float64(thin[i-1].Longitude), float64(thin[i].Latitude), float64(thin[i].Longitude))
+		}
+		f.Speed = dist / dt
+	}
+	if pl.Speed == 0 && pl.Track == 0 {
+		p := thin[len(thin)-2]
+		f.Track = bearing(float64(p.Latitude), float64(p.Longitude), float64(end.Latitude), float64(end.Longitude))
+	}
+
+	if len(thin) >= 3 {
+		var total float64
+		prev := bearing(float64(thin[0].Latitude), float64(thin[0].Longitude), float64(thin[1].Latitude), float64(thin[1].Longitude))
+		for i := 2; i < len(thin); i++ {
+			b := bearing(float64(thin[i-1].Latitude), float64(thin[i-1].Longitude), float64(thin[i].Latitude), float64(thin[i].Longitude))
+			total += math.Mod(b-prev+540, 360) - 180
+			prev = b
+		}
+		if dt := thin[len(thin)-1].Time.Sub(thin[1].Time).Seconds(); dt > 0 {
+			f.TurnRate = math.Max(-maxTurnRate, math.Min(maxTurnRate, total/dt))
+		}
+	}
+
+	return f
+}
+
+// Predict projects the fitted motion forward, a point per step from the start time until
+// the duration has passed.
+func (f *trackFit) Predict(start time.Time, d time.Duration) []PredictedPoint {
+	lat, lon := float64(f.From.Latitude), float64(f.From.Longitude)
+	track := f.Track
+	alt := float64(f.From.Altitude)
+	cur := f.From.Time
+
+	var path []PredictedPoint
+	for s := predictStep; s <= d; s += predictStep {
+		next := start.Add(s)
+		if dt := next.Sub(cur).Seconds(); dt > 0 {
+			// Move along the track at the middle of the step.
+			lat, lon = destination(lat, lon, track+f.TurnRate*dt/2, f.Speed*dt/3600)
+			track = math.Mod(track+f.TurnRate*dt+360, 360)
+			alt = math.Max(0, alt+float64(f.Vertical)*dt/60)
+			cur = next
+		}
+
+		path = append(path, PredictedPoint{Time: next, Latitude: lat, Longitude: lon, Altitude: int(alt),
+			Radius: predictBaseRadius + predictGrowth*next.Sub(f.From.Time).Minutes()})
+	}
+	return path
+}
+
+// PredictedConflict is a predicted close approach between two planes.
+type PredictedConflict struct {
+	Icao1     uint
+	Icao2     uint
+	CallSign1 string
+	CallSign2 string
+	Time      time.Time // predicted time of the closest approach
+	Distance  float64   // predicted horizontal distance in nm
+	Vertical  int       // predicted vertical distance in feet
+	Radius    float64   // combined uncertainty in nm
+	Pos1      PredictedPoint
+	Pos2      PredictedPoint
+}
+
+func (c *PredictedConflict) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"icao\": [\"%06X\", \"%06X\"], ", c.Icao1, c.Icao2))
+	buf.WriteString(fmt.Sprintf("\"callsigns\": [%q, %q], ", c.CallSign1, c.CallSign2))
+	buf.WriteString(fmt.Sprintf("\"time\": %q, ", c.Time.String()))
+	buf.WriteString(units.distance("distance", c.Distance) + ", ")
+	buf.WriteString(units.altitude("vertical", c.Vertical) + ", ")
+	buf.WriteString(units.distance("radius", c.Radius) + ", ")
+	buf.WriteString(fmt.Sprintf("\"positions\": [%s, %s]", c.Pos1.ToJson(), c.Pos2.ToJson()))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+var (
+	// Predicted conflicts from the last scan, keyed by the pair of ICAOs, lowest first.
+	conflicts    = make(map[[2]uint]*PredictedConflict)
+	lastConflict time.Time
+)
+
+// checkConflicts predicts the path of every airborne plane and looks for pairs predicted to
+// come closer than the separation minima. New conflicts are reported.
+func checkConflicts(t time.Time) {
+	if t.Sub(lastConflict) < conflictScan {
+		return
+	}
+	lastConflict = t
+
+	type prediction struct {
+		pl   *Plane
+		fit  *trackFit
+		path []PredictedPoint
+	}
+	var preds []prediction
+	for _, pl := range planeCache {
+		if pl.OnGround || pl.Altitude == 0 {
+			continue
+		}
+		f := fitTrack(pl, t)
+		if f == nil {
+			continue
+		}
+		preds = append(preds, prediction{pl, f, f.Predict(t, predictAhead)})
+	}
+	sort.Slice(preds, func(i, j int) bool { return preds[i].pl.Icao < preds[j].pl.Icao })
+
+	found := make(map[[2]uint]*PredictedConflict)
+	for i := range preds {
+		for j := i + 1; j < len(preds); j++ {
+			a, b := preds[i], preds[j]
+			if len(a.path) == 0 || len(b.path) == 0 {
+				continue
+			}
+			// Planes further apart than they can close in the time, allowing for the
+			// uncertainty of both paths, can't conflict.
+			reach := (a.fit.Speed+b.fit.Speed)*predictAhead.Hours() + separationNm +
+				2*(predictBaseRadius+predictGrowth*predictAhead.Minutes())
+			if distanceNm(a.path[0].Latitude, a.path[0].Longitude, b.path[0].Latitude, b.path[0].Longitude) > reach {
+				continue
+			}
+
+			if c := closestApproach(a.pl, b.pl, a.path, b.path); c != nil {
+				found[[2]uint{c.Icao1, c.Icao2}] = c
+			}
+		}
+	}
+
+	for key, c := range found {
+		if _, ok := conflicts[key]; !ok {
+			fmt.Printf("CONFLICT: %06X (%s) and %06X (%s) predicted %.2fnm %dft apart at %s\n",
+				c.Icao1, c.CallSign1, c.Icao2, c.CallSign2, c.Distance, c.Vertical, c.Time.Format("15:04:05"))
+			deliver(alertSinks, c.ToJson())
+		}
+	}
+	conflicts = found
+}
+
+// closestApproach returns the predicted conflict between two paths for the same times, or
+// nil if they stay separated. The planes conflict if they may be within the separation
+// given the uncertainty of their positions.
+func closestApproach(a, b *Plane, pa, pb []PredictedPoint) *PredictedConflict {
+	var best *PredictedConflict
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		dist := distanceNm(pa[i].Latitude, pa[i].Longitude, pb[i].Latitude, pb[i].Longitude)
+		vert := pa[i].Altitude - pb[i].Altitude
+		if vert < 0 {
+			vert = -vert
+		}
+		radius := pa[i].Radius + pb[i].Radius
+		if dist-radius >= separationNm || vert >= separationFt {
+			continue
+		}
+		if best == nil || dist < best.Distance {
+			best = &PredictedConflict{Icao1: a.Icao, Icao2: b.Icao, CallSign1: a.CallSign, CallSign2: b.CallSign, Time: pa[i].Time,
+				Distance: dist, Vertical: vert, Radius: radius, Pos1: pa[i], Pos2: pb[i]}
+		}
+	}
+	return best
+}
+
+// forgetConflicts drops the predicted conflicts of a plane which is no longer active.
+func forgetConflicts(icao uint) {
+	for key := range conflicts {
+		if key[0] == icao || key[1] == icao {
+			delete(conflicts, key)
+		}
+	}
+}
+
+// getPrediction returns the predicted path of the plane for the number of minutes.
+func getPrediction(icao uint, minutes string) string {
+	pl, ok := planeCache[icao]
+	if !ok {
+		return "null"
+	}
+
+	d := predictAhead
+	if minutes != "" {
+		if m, err := time.ParseDuration(minutes + "m"); err == nil && m > 0 && m <= time.Hour {
+			d = m
+		}
+	}
+
+	f := fitTrack(pl, time.Now())
+	if f == nil {
+		return "null"
+	}
+
+	path := f.Predict(f.From.Time, d)
+	sl := make([]string, len(path))
+	for i, p := range path {
+		sl[i] = p.ToJson()
+	}
+
+	return fmt.Sprintf("{\"icao\": \"%06X\", \"callsign\": %q, \"from\": {\"time\": %q, \"location\": \"%f,%f\", %s}, "+
+		"%s, \"track\": %.1f, \"turnRate\": %.2f, %s, \"path\": [%s]}",
+		pl.Icao, pl.CallSign, f.From.Time.String(), f.From.Latitude, f.From.Longitude, units.altitude("altitude", f.From.Altitude),
+		units.speed("speed", f.Speed), f.Track, f.TurnRate, units.vertical("vertical", f.Vertical), strings.Join(sl, ",\n"))
+}
+
+func getConflicts() string {
+	var cl []*PredictedConflict
+	for _, c := range conflicts {
+		cl = append(cl, c)
+	}
+	sort.Slice(cl, func(i, j int) bool { return cl[i].Time.Before(cl[j].Time) })
+
+	sl := make([]string, len(cl))
+	for i, c := range cl {
+		sl[i] = c.ToJson()
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
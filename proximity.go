@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Smallest size in degrees of the cells planes are indexed by.
+	proximityMinCell = 0.2
+	// Highest latitude at which the cells are sure to be wider than the separation.
+	proximityMaxLat = 85.0
+	// Age of another plane's position after which it is not compared.
+	proximityMaxAge = time.Second * 30
+)
+
+// Encounter is a pair of aircraft closer than the separation minima. The positions are
+// those at the closest point of approach.
+type Encounter struct {
+	id        int
+	Icao1     uint
+	Icao2     uint
+	CallSign1 string
+	CallSign2 string
+	Start     time.Time
+	End       time.Time
+	Time      time.Time // time of the closest point of approach
+	Distance  float64   // horizontal distance in nm
+	Vertical  int       // vertical distance in feet
+	Pos1      Location
+	Pos2      Location
+}
+
+func (e *Encounter) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", e.id))
+	buf.WriteString(fmt.Sprintf("\"icao\": [\"%06X\", \"%06X\"], ", e.Icao1, e.Icao2))
+	buf.WriteString(fmt.Sprintf("\"callsigns\": [%q, %q], ", e.CallSign1, e.CallSign2))
+	buf.WriteString(fmt.Sprintf("\"start\": %q, ", e.Start.String()))
+	if !e.End.IsZero() {
+		buf.WriteString(fmt.Sprintf("\"end\": %q, ", e.End.String()))
+	}
+	buf.WriteString(fmt.Sprintf("\"cpa\": {\"time\": %q, %s, %s, ", e.Time.String(), units.distance("distance", e.Distance),
+		units.altitude("vertical", e.Vertical)))
+	buf.WriteString(fmt.Sprintf("\"locations\": [\"%f,%f\", \"%f,%f\"], ", e.Pos1.Latitude, e.Pos1.Longitude, e.Pos2.Latitude, e.Pos2.Longitude))
+	buf.WriteString(unitField("altitudes", fmt.Sprintf("[%s, %s]", units.altitudeValue(e.Pos1.Altitude), units.altitudeValue(e.Pos2.Altitude)),
+		units.Altitude) + "}")
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+var (
+	// Size in degrees of the cells planes are indexed by, set by initProximity. It must be
+	// larger than the horizontal separation, as only neighbouring cells are searched.
+	proximityCell = proximityMinCell
+	// Active planes in each cell, and the cell each plane is in.
+	proximityCells = make(map[[2]int]map[uint]bool)
+	planeCells     = make(map[uint][2]int)
+	// Encounters in progress, keyed by the pair of ICAOs, lowest first.
+	encounters = make(map[[2]uint]*Encounter)
+)
+
+// initProximity sizes the cells for the separation, so that a degree of longitude is
+// wide enough up to the highest latitude.
+func initProximity() {
+	proximityCell = math.Max(proximityMinCell, separationNm/(60*math.Cos(toRad(proximityMaxLat))))
+}
+
+func proximityCellOf(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat / proximityCell)), int(math.Floor(lon / proximityCell))}
+}
+
+// checkProximity compares the plane's new position with the other planes nearby,
+// starting, updating and ending encounters.
+func checkProximity(pl *Plane, t time.Time) {
+	if len(pl.Locations) == 0 {
+		return
+	}
+	l := pl.Locations[len(pl.Locations)-1]
+	lat, lon := float64(l.Latitude), float64(l.Longitude)
+
+	cell := proximityCellOf(lat, lon)
+	if old, ok := planeCells[pl.Icao]; !ok || old != cell {
+		if ok {
+			delete(proximityCells[old], pl.Icao)
+		}
+		if proximityCells[cell] == nil {
+			proximityCells[cell] = make(map[uint]bool)
+		}
+		proximityCells[cell][pl.Icao] = true
+		planeCells[pl.Icao] = cell
+	}
+
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			for icao := range proximityCells[[2]int{cell[0] + dy, cell[1] + dx}] {
+				if icao == pl.Icao {
+					continue
+				}
+				other, ok := planeCache[icao]
+				if !ok || len(other.Locations) == 0 {
+					continue
+				}
+				comparePlanes(pl, other, t)
+			}
+		}
+	}
+
+	// End encounters with planes which are no longer close enough to be compared.
+	for key, e := range encounters {
+		if key[0] != pl.Icao && key[1] != pl.Icao {
+			continue
+		}
+		other := key[0]
+		if other == pl.Icao {
+			other = key[1]
+		}
+		oc, ok := planeCells[other]
+		if !ok || abs(oc[0]-cell[0]) > 1 || abs(oc[1]-cell[1]) > 1 {
+			endEncounter(key, e, t)
+		}
+	}
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// comparePlanes checks the separation of a pair of planes.
+func comparePlanes(pl, other *Plane, t time.Time) {
+	a := pl.Locations[len(pl.Locations)-1]
+	b := other.Locations[len(other.Locations)-1]
+
+	key := [2]uint{pl.Icao, other.Icao}
+	if key[0] > key[1] {
+		key[0], key[1] = key[1], key[0]
+	}
+	e := encounters[key]
+
+	near := t.Sub(b.Time) <= proximityMaxAge && !pl.OnGround && !other.OnGround &&
+		pl.Altitude != 0 && other.Altitude != 0
+	dist := distanceNm(float64(a.Latitude), float64(a.Longitude), float64(b.Latitude), float64(b.Longitude))
+	vert := pl.Altitude - other.Altitude
+	if vert < 0 {
+		vert = -vert
+	}
+	near = near && dist < separationNm && vert < separationFt && !atSameAirport(a, b, pl.Altitude, other.Altitude)
+
+	if !near {
+		if e != nil {
+			endEncounter(key, e, t)
+		}
+		return
+	}
+
+	a.Altitude, b.Altitude = pl.Altitude, other.Altitude
+	if pl.Icao != key[0] {
+		a, b = b, a
+	}
+
+	if e == nil {
+		e = &Encounter{Icao1: key[0], Icao2: key[1], Start: t, Time: t, Distance: dist, Vertical: vert, Pos1: a, Pos2: b}
+		e.CallSign1, e.CallSign2 = pl.CallSign, other.CallSign
+		if pl.Icao != key[0] {
+			e.CallSign1, e.CallSign2 = e.CallSign2, e.CallSign1
+		}
+		encounters[key] = e
+
+		fmt.Printf("PROXIMITY: %06X (%s) and %06X (%s) %.2fnm %dft apart\n", e.Icao1, e.CallSign1, e.Icao2, e.CallSign2, dist, vert)
+		deliver(alertSinks, e.ToJson())
+		return
+	}
+
+	if dist < e.Distance || (dist == e.Distance && vert < e.Vertical) {
+		e.Time, e.Distance, e.Vertical, e.Pos1, e.Pos2 = t, dist, vert, a, b
+	}
+}
+
+// atSameAirport returns true if both positions are low near the same airport, where
+// aircraft are routinely close together.
+func atSameAirport(a, b Location, altA, altB int) bool {
+	for _, ap := range nearbyAirports(float64(a.Latitude), float64(a.Longitude)) {
+		if altA-ap.Elevation >= approachHeight || altB-ap.Elevation >= approachHeight {
+			continue
+		}
+		if distanceNm(float64(b.Latitude), float64(b.Longitude), ap.Latitude, ap.Longitude) <= movementRadius {
+			return true
+		}
+	}
+	return false
+}
+
+func endEncounter(key [2]uint, e *Encounter, t time.Time) {
+	delete(encounters, key)
+	e.End = t
+	if t.IsZero() {
+		// Shutting down, so the end is not known.
+		e.End = e.Time
+	}
+
+	if verbose {
+		fmt.Printf("Proximity: %06X and %06X separated, closest %.2fnm %dft\n", e.Icao1, e.Icao2, e.Distance, e.Vertical)
+	}
+	go func() {
+		err := SaveEncounter(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving encounter: %v\n", err)
+		}
+	}()
+}
+
+// forgetProximity removes a plane which is no longer active from the index, ending its encounters.
+func forgetProximity(icao uint, t time.Time) {
+	if cell, ok := planeCells[icao]; ok {
+		delete(proximityCells[cell], icao)
+		if len(proximityCells[cell]) == 0 {
+			delete(proximityCells, cell)
+		}
+		delete(planeCells, icao)
+	}
+
+	for key, e := range encounters {
+		if key[0] == icao || key[1] == icao {
+			endEncounter(key, e, t)
+		}
+	}
+}
+
+// getEncounters returns the stored encounters since the time, followed by those in progress.
+func getEncounters(t time.Time) string {
+	stored, err := LoadEncounters(t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading encounters: %v\n", err)
+	}
+
+	var sl []string
+	for _, e := range stored {
+		sl = append(sl, e.ToJson())
+	}
+
+	var active []*Encounter
+	for _, e := range encounters {
+		active = append(active, e)
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Start.Before(active[j].Start) })
+	for _, e := range active {
+		sl = append(sl, e.ToJson())
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
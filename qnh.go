@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Standard pressure in hPa, which pressure altitude is relative to.
+const stdPressure = 1013.25
+
+// Range of QNH accepted, in hPa.
+const (
+	minQnh = 850.0
+	maxQnh = 1090.0
+)
+
+var (
+	// Local QNH in hPa, or 0 if unknown.
+	qnh       float64
+	qnhSource string
+	qnhTime   time.Time
+	// Modification time of the QNH file when it was last read.
+	qnhFileTime time.Time
+)
+
+// parseQnh reads a QNH in hPa, such as 1013.2 or Q1013, or in inches of mercury, such as
+// 29.92 or A2992. In a METAR only the Q or A group is read.
+func parseQnh(s string) (float64, error) {
+	fields := strings.Fields(strings.ToUpper(s))
+	var v float64
+	var err error
+	switch {
+	case len(fields) == 1 && !strings.HasPrefix(fields[0], "Q") && !strings.HasPrefix(fields[0], "A"):
+		v, err = strconv.ParseFloat(fields[0], 64)
+		if err == nil && v < 100 {
+			v *= 33.8639
+		}
+	default:
+		err = fmt.Errorf("no QNH in %q", s)
+		for _, w := range fields {
+			if len(w) != 5 || (w[0] != 'Q' && w[0] != 'A') {
+				continue
+			}
+			n, e := strconv.Atoi(w[1:])
+			if e != nil {
+				continue
+			}
+			v, err = float64(n), nil
+			if w[0] == 'A' {
+				v = v / 100 * 33.8639
+			}
+			break
+		}
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid QNH")
+	}
+	if v < minQnh || v > maxQnh {
+		return 0, fmt.Errorf("QNH out of range: %.1f", v)
+	}
+	return v, nil
+}
+
+// parseQnhBody reads the QNH from a JSON request body, as a number in hPa or a string
+// accepted by parseQnh.
+func parseQnhBody(body string) (float64, error) {
+	var in struct {
+		Qnh interface{} `json:"qnh"`
+	}
+	err := json.Unmarshal([]byte(body), &in)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid QNH")
+	}
+	if in.Qnh == nil {
+		return 0, errors.New("missing QNH")
+	}
+	return parseQnh(fmt.Sprint(in.Qnh))
+}
+
+func setQnh(v float64, source string, t time.Time) {
+	if verbose && v != qnh {
+		fmt.Printf("QNH: %.1fhPa from %s\n", v, source)
+	}
+	qnh, qnhSource, qnhTime = v, source, t.Round(0)
+}
+
+// loadQnhFile reads the QNH from the file if it has changed since it was last read, so
+// another program can keep it up to date.
+func loadQnhFile(file string) error {
+	if file == "" {
+		return nil
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return errors.Wrap(err, "unable to read QNH file")
+	}
+	if fi.ModTime().Equal(qnhFileTime) {
+		return nil
+	}
+	qnhFileTime = fi.ModTime()
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "unable to read QNH file")
+	}
+	v, err := parseQnh(string(b))
+	if err != nil {
+		return err
+	}
+	setQnh(v, "file", fi.ModTime())
+	return nil
+}
+
+// correctedAltitude returns the plane's barometric altitude corrected for the local QNH,
+// an estimate of its altitude above mean sea level. Returns false if the QNH or altitude
+// is unknown.
+func correctedAltitude(pl *Plane) (int, bool) {
+	if qnh == 0 || pl.Altitude == 0 {
+		return 0, false
+	}
+	// Invert the standard atmosphere from the static pressure at the pressure altitude.
+	k := math.Pow(stdPressure/qnh, 0.190284)
+	h := 145366.45 * (1 - k*(1-float64(pl.Altitude)/145366.45))
+	return int(math.Round(h)), true
+}
+
+// qnhCommand returns the local QNH, after setting it from the body of a POST.
+func qnhCommand(method, body string, t time.Time) string {
+	if method == "POST" {
+		v, err := parseQnhBody(body)
+		if err != nil {
+			return "null"
+		}
+		setQnh(v, "http", t)
+	}
+
+	if qnh == 0 {
+		return "{\"qnh\": null}"
+	}
+	return fmt.Sprintf("{\"qnh\": %.1f, \"inHg\": %.2f, \"source\": %q, \"time\": %q}", qnh, qnh/33.8639, qnhSource, qnhTime.String())
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// US N-number allocation. Letters exclude I and O.
+const (
+	nLetters     = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	nDigits      = "0123456789"
+	nStart       = 0xA00001
+	nEnd         = 0xADF7C7
+	nSuffixSize  = 1 + len(nLetters)*(1+len(nLetters))     // 601
+	nBucket4Size = 1 + len(nLetters) + len(nDigits)        // 35
+	nBucket3Size = len(nDigits)*nBucket4Size + nSuffixSize // 951
+	nBucket2Size = len(nDigits)*nBucket3Size + nSuffixSize // 10111
+	nBucket1Size = len(nDigits)*nBucket2Size + nSuffixSize // 101711
+)
+
+// strideBlock is a block of registrations made of a prefix and three letters, which are
+// allocated addresses in order. The address of the first letter is multiplied by S1,
+// the second by S2 and the third by 1.
+type strideBlock struct {
+	Start  uint
+	S1     uint
+	S2     uint
+	Prefix string
+}
+
+const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+var strideBlocks = []strideBlock{
+	{Start: 0xC00001, S1: 26 * 26, S2: 26, Prefix: "C-F"},
+	{Start: 0xC044A9, S1: 26 * 26, S2: 26, Prefix: "C-G"},
+	{Start: 0x448421, S1: 1024, S2: 32, Prefix: "OO-"},
+	{Start: 0x458421, S1: 1024, S2: 32, Prefix: "OY-"},
+	{Start: 0x490421, S1: 1024, S2: 32, Prefix: "CS-"},
+	{Start: 0x4B8421, S1: 1024, S2: 32, Prefix: "TC-"},
+}
+
+// icaoToRegistration derives the registration from the address for the countries which
+// allocate addresses systematically. Returns an empty string if it cannot be derived.
+func icaoToRegistration(icao uint) string {
+	if icao >= nStart && icao <= nEnd {
+		return icaoToN(icao)
+	}
+
+	for _, b := range strideBlocks {
+		if icao < b.Start {
+			continue
+		}
+		off := icao - b.Start
+		c1 := off / b.S1
+		c2 := (off % b.S1) / b.S2
+		c3 := off % b.S2
+		if c1 < 26 && c2 < 26 && c3 < 26 {
+			return b.Prefix + string([]byte{letters[c1], letters[c2], letters[c3]})
+		}
+	}
+
+	return ""
+}
+
+// registrationToIcao is the reverse of icaoToRegistration. Returns 0 if the address
+// cannot be derived from the registration.
+func registrationToIcao(reg string) uint {
+	reg = normalizeRegistration(reg)
+
+	if strings.HasPrefix(reg, "N") {
+		return nToIcao(reg[1:])
+	}
+
+	for _, b := range strideBlocks {
+		if !strings.HasPrefix(reg, b.Prefix) || len(reg) != len(b.Prefix)+3 {
+			continue
+		}
+		var c [3]uint
+		for i := range c {
+			idx := strings.IndexByte(letters, reg[len(b.Prefix)+i])
+			if idx < 0 {
+				return 0
+			}
+			c[i] = uint(idx)
+		}
+		return b.Start + c[0]*b.S1 + c[1]*b.S2 + c[2]
+	}
+
+	return 0
+}
+
+// normalizeRegistration upper cases the registration, removing spaces. The hyphen is added
+// to registrations of the stride blocks written without one, such as CFABC.
+func normalizeRegistration(reg string) string {
+	reg = strings.ToUpper(strings.Replace(strings.TrimSpace(reg), " ", "", -1))
+	if strings.Contains(reg, "-") {
+		return reg
+	}
+	for _, b := range strideBlocks {
+		bare := strings.Replace(b.Prefix, "-", "", -1)
+		if strings.HasPrefix(reg, bare) && len(reg) == len(bare)+3 {
+			return b.Prefix + reg[len(bare):]
+		}
+	}
+	return reg
+}
+
+// nSuffix returns the up to two letter suffix for an offset within a suffix block.
+func nSuffix(off int) string {
+	if off == 0 {
+		return ""
+	}
+	c := nLetters[(off-1)/(len(nLetters)+1)]
+	rem := (off - 1) % (len(nLetters) + 1)
+	if rem == 0 {
+		return string(c)
+	}
+	return string([]byte{c, nLetters[rem-1]})
+}
+
+// nSuffixOffset returns the offset of an up to two letter suffix within a suffix block.
+func nSuffixOffset(s string) int {
+	if len(s) == 0 {
+		return 0
+	}
+	i := strings.IndexByte(nLetters, s[0])
+	off := i*(len(nLetters)+1) + 1
+	if len(s) == 2 {
+		off += strings.IndexByte(nLetters, s[1]) + 1
+	}
+	return off
+}
+
+func icaoToN(icao uint) string {
+	off := int(icao - nStart)
+	out := "N"
+
+	out += string(nDigits[off/nBucket1Size+1])
+	off %= nBucket1Size
+	if off < nSuffixSize {
+		return out + nSuffix(off)
+	}
+
+	for _, size := range []int{nBucket2Size, nBucket3Size} {
+		off -= nSuffixSize
+		out += string(nDigits[off/size])
+		off %= size
+		if off < nSuffixSize {
+			return out + nSuffix(off)
+		}
+	}
+
+	off -= nSuffixSize
+	out += string(nDigits[off/nBucket4Size])
+	off %= nBucket4Size
+	if off == 0 {
+		return out
+	}
+	return out + string((nLetters + nDigits)[off-1])
+}
+
+// nToIcao converts the part of an N-number after the N to its address.
+func nToIcao(n string) uint {
+	if len(n) == 0 || len(n) > 5 || n[0] < '1' || n[0] > '9' {
+		return 0
+	}
+	// Only the last place may mix letters and digits, and letters may only follow digits.
+	for i := 1; i < len(n); i++ {
+		isLetter := strings.IndexByte(nLetters, n[i]) >= 0
+		isDigit := n[i] >= '0' && n[i] <= '9'
+		if !isLetter && !isDigit {
+			return 0
+		}
+		if isLetter {
+			rest := n[i:]
+			if len(rest) > 2 {
+				return 0
+			}
+			for j := 0; j < len(rest); j++ {
+				if strings.IndexByte(nLetters, rest[j]) < 0 {
+					return 0
+				}
+			}
+			break
+		}
+	}
+
+	out := nStart + int(n[0]-'1')*nBucket1Size
+	sizes := []int{nBucket2Size, nBucket3Size, nBucket4Size}
+	for i := 1; i < len(n); i++ {
+		if strings.IndexByte(nLetters, n[i]) >= 0 {
+			if i == 4 {
+				return uint(out + 1 + strings.IndexByte(nLetters, n[i]))
+			}
+			return uint(out + nSuffixOffset(n[i:]))
+		}
+		if i == 4 {
+			return uint(out + 1 + len(nLetters) + int(n[i]-'0'))
+		}
+		out += nSuffixSize + int(n[i]-'0')*sizes[i-1]
+	}
+
+	return uint(out)
+}
+
+// lookupRegistration finds the ICAO of a registration, from the aircraft database or
+// derived from the registration, and returns it with the stored plane and its locations.
+func lookupRegistration(reg string, t time.Time) string {
+	reg = normalizeRegistration(reg)
+
+	source := "database"
+	icao, err := LoadIcaoByRegistration(reg)
+	if err != nil && err != aircraftNotFound {
+		fmt.Fprintf(os.Stderr, "error looking up registration: %v\n", err)
+	}
+	if icao == 0 {
+		source = "derived"
+		icao = registrationToIcao(reg)
+	}
+	if icao == 0 {
+		return fmt.Sprintf("{\"registration\": %q, \"icao\": null}", reg)
+	}
+
+	plane := detailedPlane(icao)
+	if plane == "" {
+		plane = "null"
+	}
+
+	return fmt.Sprintf("{\"registration\": %q, \"icao\": \"%06X\", \"source\": %q, \"plane\": %s, \"locations\": %s}",
+		reg, icao, source, plane, getPlaneLocations(icao, t))
+}
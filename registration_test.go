@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNRegistrationRoundTrip(t *testing.T) {
+	for icao := uint(nStart); icao <= nEnd; icao++ {
+		reg := icaoToRegistration(icao)
+		if reg == "" {
+			t.Fatalf("icaoToRegistration(%06X) is empty", icao)
+		}
+		if got := registrationToIcao(reg); got != icao {
+			t.Fatalf("registrationToIcao(%q) = %06X, expected %06X", reg, got, icao)
+		}
+	}
+}
+
+func TestRegistrationToIcao(t *testing.T) {
+	tests := []struct {
+		reg  string
+		want uint
+	}{
+		{"N1", 0xA00001},
+		{"N1A", 0xA00002},
+		{"N99999", 0xADF7C7},
+		{"C-FAAA", 0xC00001},
+		{"CFAAA", 0xC00001},
+		{"c-gaaa", 0xC044A9},
+		{"GABCD", 0},
+		{"OY-ABC", 0x458421 + 1*32 + 2},
+		{"OOABC", 0x448421 + 1*32 + 2},
+		{"N0", 0},
+		{"NABC", 0},
+		{"C-FAA", 0},
+		{"", 0},
+	}
+	for _, test := range tests {
+		if got := registrationToIcao(test.reg); got != test.want {
+			t.Errorf("registrationToIcao(%q) = %06X, expected %06X", test.reg, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeRegistration(t *testing.T) {
+	tests := map[string]string{
+		"cfabc":   "C-FABC",
+		" C FABC": "C-FABC",
+		"C-FABC":  "C-FABC",
+		"TCJJA":   "TC-JJA",
+		"GABCD":   "GABCD",
+		"n123ab":  "N123AB",
+		"CFABCD":  "CFABCD",
+	}
+	for reg, want := range tests {
+		if got := normalizeRegistration(reg); got != want {
+			t.Errorf("normalizeRegistration(%q) = %q, expected %q", reg, got, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+// Route is the expected airports, in order, for a callsign.
+type Route struct {
+	CallSign string
+	Airports []string
+}
+
+// Origin returns the first airport of the route, if it is known.
+func (r *Route) Origin() *Airport {
+	if len(r.Airports) == 0 {
+		return nil
+	}
+	return airports[r.Airports[0]]
+}
+
+// Destination returns the last airport of the route, if it is known.
+func (r *Route) Destination() *Airport {
+	if len(r.Airports) < 2 {
+		return nil
+	}
+	return airports[r.Airports[len(r.Airports)-1]]
+}
+
+// Distance returns the distance in nm from the position to the nearest leg of the route.
+// Returns false if the location of the airports is not known.
+func (r *Route) Distance(lat, lon float64) (float64, bool) {
+	p := point{Lat: lat, Lon: lon}
+	best := -1.0
+	for i := 1; i < len(r.Airports); i++ {
+		a, b := airports[r.Airports[i-1]], airports[r.Airports[i]]
+		if a == nil || b == nil {
+			continue
+		}
+		d := distanceToSegment(p, point{Lat: a.Latitude, Lon: a.Longitude}, point{Lat: b.Latitude, Lon: b.Longitude})
+		if best < 0 || d < best {
+			best = d
+		}
+	}
+	return best, best >= 0
+}
+
+func (r *Route) ToJson(offRoute bool) string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{\"airports\": [")
+	for i, a := range r.Airports {
+		buf.WriteString(fmt.Sprintf("%q", a))
+		if i != len(r.Airports)-1 {
+			buf.WriteString(", ")
+		}
+	}
+	buf.WriteString("], \"origin\": ")
+	if o := r.Origin(); o != nil {
+		buf.WriteString(o.ToJson())
+	} else {
+		buf.WriteString("null")
+	}
+	buf.WriteString(", \"destination\": ")
+	if d := r.Destination(); d != nil {
+		buf.WriteString(d.ToJson())
+	} else {
+		buf.WriteString("null")
+	}
+	buf.WriteString(fmt.Sprintf(", \"offRoute\": %v}", offRoute))
+
+	return buf.String()
+}
+
+// findRoute returns the route for the callsign, or nil if there is none.
+func findRoute(cs string) *Route {
+	if cs == "" {
+		return nil
+	}
+
+	r, err := LoadRoute(strings.ToUpper(cs))
+	if err != nil {
+		if err != routeNotFound {
+			fmt.Fprintf(os.Stderr, "error loading route: %v\n", err)
+		}
+		return nil
+	}
+	return r
+}
+
+// routeJson returns the route for the callsign as JSON, or null if there is none.
+func routeJson(cs string) string {
+	if r := findRoute(cs); r != nil {
+		return r.ToJson(false)
+	}
+	return "null"
+}
+
+// updateRoute looks up the route for the plane's current callsign.
+func updateRoute(pl *Plane) {
+	pl.Route = findRoute(pl.CallSign)
+	pl.OffRoute = false
+	checkRoute(pl)
+}
+
+// checkRoute flags the plane as off route if its latest position is further than the
+// route tolerance from every leg of its expected route.
+func checkRoute(pl *Plane) {
+	if pl.Route == nil || len(pl.Locations) == 0 {
+		return
+	}
+
+	l := pl.Locations[len(pl.Locations)-1]
+	d, ok := pl.Route.Distance(float64(l.Latitude), float64(l.Longitude))
+	if !ok {
+		return
+	}
+
+	off := d > routeTolerance
+	if off && !pl.OffRoute {
+		fmt.Printf("Route: %06X (%s) is %.0fnm from its route %s\n", pl.Icao, pl.CallSign, d, strings.Join(pl.Route.Airports, "-"))
+	}
+	pl.OffRoute = off
+}
+
+// importRoutes is the import-routes sub command. It reads routes files from the standing
+// data (Callsign, Code, Number, AirlineCode, AirportCodes), either single files or
+// directories of them.
+func importRoutes(args []string) error {
+	fs := flag.NewFlagSet("import-routes", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import-routes <file or directory>...\n", os.Args[0])
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing routes file")
+	}
+
+	files, err := csvFiles(fs.Args())
+	if err != nil {
+		return errors.Wrap(err, "unable to find routes files")
+	}
+
+	var routes []*Route
+	for _, file := range files {
+		err = readCsvFile(file, func(fields []string, h map[string]int) error {
+			r := &Route{CallSign: strings.ToUpper(csvField(fields, h, "CALLSIGN"))}
+			for _, a := range strings.Split(csvField(fields, h, "AIRPORTCODES"), "-") {
+				if a = strings.ToUpper(strings.TrimSpace(a)); a != "" {
+					r.Airports = append(r.Airports, a)
+				}
+			}
+			if r.CallSign != "" && len(r.Airports) >= 2 {
+				routes = append(routes, r)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = SaveRoutes(routes)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d routes\n", len(routes))
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of recent matches kept for each rule.
+const ruleRecent = 20
+
+// Rule raises a notification when its expression is true for a plane. Matches for the
+// same plane are repeated at most once per cooldown. Rules in dry run only record their
+// matches, so they can be tried out before anyone is notified.
+type Rule struct {
+	id       int
+	Name     string
+	Expr     string
+	Cooldown time.Duration
+	Sinks    []string // names of sinks from the command line, or none for the alert sinks
+	DryRun   bool
+
+	expr    Expr
+	sinks   []Sink
+	matches int
+	recent  []string // recent matches, newest last
+}
+
+func (r *Rule) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", r.id))
+	buf.WriteString(fmt.Sprintf("\"name\": %q, ", r.Name))
+	buf.WriteString(fmt.Sprintf("\"expr\": %q, ", r.Expr))
+	buf.WriteString(fmt.Sprintf("\"cooldown\": %q, ", r.Cooldown.String()))
+	buf.WriteString(fmt.Sprintf("\"sinks\": %s, ", jsonStrings(r.Sinks)))
+	buf.WriteString(fmt.Sprintf("\"dryRun\": %v, ", r.DryRun))
+	buf.WriteString(fmt.Sprintf("\"matches\": %d, ", r.matches))
+	buf.WriteString(fmt.Sprintf("\"recent\": [%s]", strings.Join(r.recent, ", ")))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+// compile parses the expression and looks up the sinks of the rule.
+func (r *Rule) compile() error {
+	e, err := ParseExpr(r.Expr)
+	if err != nil {
+		return errors.Wrap(err, "invalid expression")
+	}
+	err = checkFields(e)
+	if err != nil {
+		return errors.Wrap(err, "invalid expression")
+	}
+
+	var sinks []Sink
+	for _, name := range r.Sinks {
+		s, ok := namedSinks[name]
+		if !ok {
+			return fmt.Errorf("unknown sink: %q", name)
+		}
+		sinks = append(sinks, s)
+	}
+
+	r.expr, r.sinks = e, sinks
+	return nil
+}
+
+// parseRule reads a rule from a JSON request body and compiles it.
+func parseRule(body string) (*Rule, error) {
+	var in struct {
+		Name     string   `json:"name"`
+		Expr     string   `json:"expr"`
+		Cooldown string   `json:"cooldown"`
+		Sinks    []string `json:"sinks"`
+		DryRun   bool     `json:"dryRun"`
+	}
+	err := json.Unmarshal([]byte(body), &in)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid rule")
+	}
+
+	r := &Rule{Name: in.Name, Expr: in.Expr, Sinks: in.Sinks, DryRun: in.DryRun, Cooldown: alertCooldown}
+	if in.Cooldown != "" {
+		r.Cooldown, err = time.ParseDuration(in.Cooldown)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid cooldown")
+		}
+	}
+	if r.Name == "" {
+		r.Name = r.Expr
+	}
+
+	err = r.compile()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseTestRule reads the expression to test from a JSON request body.
+func parseTestRule(body string) (Expr, error) {
+	var in struct {
+		Expr string `json:"expr"`
+	}
+	err := json.Unmarshal([]byte(body), &in)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid test")
+	}
+
+	e, err := ParseExpr(in.Expr)
+	if err == nil {
+		err = checkFields(e)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid expression")
+	}
+	return e, nil
+}
+
+var (
+	rules []*Rule
+	// Time a rule last matched a plane, keyed by rule id and icao.
+	ruleFired = make(map[string]time.Time)
+)
+
+func loadRules() error {
+	var err error
+	rules, err = LoadRules()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		err = r.compile()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in rule %q, it is disabled: %v\n", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// evalRule returns true if the expression is true for the plane. Evaluation errors, such as
+// comparing a missing value, count as false.
+func evalRule(e Expr, pl *Plane) bool {
+	v, err := e.Eval(func(name string) (interface{}, bool) { return planeField(pl, name) })
+	if err != nil {
+		if veryVerbose {
+			fmt.Printf("Rule error for %06X: %v\n", pl.Icao, err)
+		}
+		return false
+	}
+	b, _ := v.(bool)
+	return b
+}
+
+// checkRules evaluates every rule against the plane, notifying the rule's sinks of matches.
+func checkRules(pl *Plane, t time.Time) {
+	for _, r := range rules {
+		if r.expr == nil || !evalRule(r.expr, pl) {
+			continue
+		}
+
+		key := fmt.Sprintf("%d|%06X", r.id, pl.Icao)
+		if last, ok := ruleFired[key]; ok && t.Sub(last) < r.Cooldown {
+			continue
+		}
+		ruleFired[key] = t
+
+		r.matches++
+		if len(r.recent) >= ruleRecent {
+			r.recent = r.recent[1:]
+		}
+		r.recent = append(r.recent, fmt.Sprintf("{\"icao\": \"%06X\", \"callsign\": %q, \"time\": %q}", pl.Icao, pl.CallSign, t.String()))
+
+		if r.DryRun {
+			if verbose {
+				fmt.Printf("Rule (dry run): %06X (%s) matched %q\n", pl.Icao, pl.CallSign, r.Name)
+			}
+			continue
+		}
+
+		fmt.Printf("RULE: %06X (%s) matched %q\n", pl.Icao, pl.CallSign, r.Name)
+		payload := fmt.Sprintf("{\"rule\": %q, \"id\": %d, \"time\": %q, \"plane\": %s}", r.Name, r.id, t.String(), pl.ToJson())
+		if len(r.sinks) > 0 {
+			deliver(r.sinks, payload)
+		} else {
+			deliver(alertSinks, payload)
+		}
+	}
+}
+
+// forgetRules drops the expired cooldowns of a plane which is no longer active.
+func forgetRules(icao uint, t time.Time) {
+	for _, r := range rules {
+		key := fmt.Sprintf("%d|%06X", r.id, icao)
+		if last, ok := ruleFired[key]; ok && t.Sub(last) >= r.Cooldown {
+			delete(ruleFired, key)
+		}
+	}
+}
+
+// resetRule drops the cooldowns of a rule which has been replaced or deleted.
+func resetRule(id int) {
+	prefix := fmt.Sprintf("%d|", id)
+	for key := range ruleFired {
+		if strings.HasPrefix(key, prefix) {
+			delete(ruleFired, key)
+		}
+	}
+}
+
+// testRule returns the active planes the expression is true for.
+func testRule(body string) string {
+	e, err := parseTestRule(body)
+	if err != nil {
+		return "[]"
+	}
+
+	sl := []string{}
+	for _, pl := range planeCache {
+		if evalRule(e, pl) {
+			sl = append(sl, pl.ToJson())
+		}
+	}
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
+
+func findRule(id int) (int, *Rule) {
+	for i, r := range rules {
+		if r.id == id {
+			return i, r
+		}
+	}
+	return -1, nil
+}
+
+// rulesCommand lists, reads, creates, updates or deletes rules depending on the HTTP method.
+// POST to test evaluates an expression against the active planes. Returns null for an
+// unknown rule.
+func rulesCommand(method, arg, body string) string {
+	if arg == "test" {
+		return testRule(body)
+	}
+
+	id, _ := strconv.Atoi(arg)
+	i, r := findRule(id)
+
+	switch method {
+	case "POST":
+		nr, err := parseRule(body)
+		if err != nil {
+			return "null"
+		}
+		err = SaveRule(nr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving rule: %v\n", err)
+			return "null"
+		}
+		rules = append(rules, nr)
+		return nr.ToJson()
+	case "PUT":
+		nr, err := parseRule(body)
+		if r == nil || err != nil {
+			return "null"
+		}
+		nr.id = r.id
+		err = SaveRule(nr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving rule: %v\n", err)
+			return "null"
+		}
+		rules[i] = nr
+		resetRule(r.id)
+		return nr.ToJson()
+	case "DELETE":
+		if r == nil {
+			return "null"
+		}
+		err := DeleteRule(r.id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error deleting rule: %v\n", err)
+			return "null"
+		}
+		rules = append(rules[:i], rules[i+1:]...)
+		resetRule(r.id)
+		return r.ToJson()
+	}
+
+	if arg != "" {
+		if r == nil {
+			return "null"
+		}
+		return r.ToJson()
+	}
+
+	sl := make([]string, len(rules))
+	for i, r := range rules {
+		sl[i] = r.ToJson()
+	}
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Period of movements used to work out the runways in use.
+	runwayWindow = time.Minute * 30
+	// Number of movements needed in the window before the configuration can change.
+	runwayMinMovements = 3
+	// Share of the movements of a kind needed for a runway to be counted as in use.
+	runwayMinShare = 0.2
+)
+
+// RunwayConfig is the set of runway ends an airport is using for arrivals and departures.
+type RunwayConfig struct {
+	id         int
+	Airport    string
+	Time       time.Time
+	Arrivals   []string
+	Departures []string
+}
+
+// Key returns a string which is the same for configurations using the same runways.
+func (c *RunwayConfig) Key() string {
+	return strings.Join(c.Arrivals, ",") + "|" + strings.Join(c.Departures, ",")
+}
+
+func (c *RunwayConfig) ToJson() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	buf.WriteString(fmt.Sprintf("\"id\": %d, ", c.id))
+	buf.WriteString(fmt.Sprintf("\"airport\": %q, ", c.Airport))
+	buf.WriteString(fmt.Sprintf("\"since\": %q, ", c.Time.String()))
+	buf.WriteString(fmt.Sprintf("\"arrivals\": %s, ", jsonStrings(c.Arrivals)))
+	buf.WriteString(fmt.Sprintf("\"departures\": %s", jsonStrings(c.Departures)))
+	buf.WriteString("}")
+
+	return buf.String()
+}
+
+func jsonStrings(sl []string) string {
+	q := make([]string, len(sl))
+	for i, s := range sl {
+		q[i] = fmt.Sprintf("%q", s)
+	}
+	return "[" + strings.Join(q, ", ") + "]"
+}
+
+// runwayUse is the recent movements and current configuration of a monitored airport.
+type runwayUse struct {
+	Recent  []*Movement
+	Current *RunwayConfig
+}
+
+// Airports whose runway configuration is tracked, by ident.
+var runwayAirportUse = make(map[string]*runwayUse)
+
+// initRunwayUse sets up tracking for each comma separated airport, starting from the
+// last stored configuration.
+func initRunwayUse(idents string) {
+	for _, ident := range strings.Split(idents, ",") {
+		ident = strings.ToUpper(strings.TrimSpace(ident))
+		if ident == "" {
+			continue
+		}
+		if _, ok := airports[ident]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown airport for runway tracking: %q\n", ident)
+		}
+
+		ru := &runwayUse{}
+		c, err := LoadLatestRunwayConfig(ident)
+		if err != nil && err != runwayConfigNotFound {
+			fmt.Fprintf(os.Stderr, "error loading runway configuration: %v\n", err)
+		}
+		ru.Current = c
+		runwayAirportUse[ident] = ru
+	}
+}
+
+// updateRunwayUse adds a movement to its airport's recent movements, recording a new
+// configuration if the runways in use have changed.
+func updateRunwayUse(m *Movement) {
+	ru, ok := runwayAirportUse[m.Airport]
+	if !ok || m.Runway == "" {
+		return
+	}
+
+	// Drop movements which have left the window.
+	recent := ru.Recent[:0]
+	for _, r := range ru.Recent {
+		if m.Time.Sub(r.Time) <= runwayWindow {
+			recent = append(recent, r)
+		}
+	}
+	ru.Recent = append(recent, m)
+	if len(ru.Recent) < runwayMinMovements {
+		return
+	}
+
+	c := &RunwayConfig{Airport: m.Airport, Time: m.Time}
+	c.Arrivals = runwaysInUse(ru.Recent, MovementArrival)
+	c.Departures = runwaysInUse(ru.Recent, MovementDeparture)
+	// Keep the runways of the last configuration for a kind with no recent movements.
+	if ru.Current != nil {
+		if len(c.Arrivals) == 0 {
+			c.Arrivals = ru.Current.Arrivals
+		}
+		if len(c.Departures) == 0 {
+			c.Departures = ru.Current.Departures
+		}
+	}
+
+	if ru.Current != nil && ru.Current.Key() == c.Key() {
+		return
+	}
+	ru.Current = c
+
+	fmt.Printf("Runways: %s now arriving %s departing %s\n", c.Airport, strings.Join(c.Arrivals, ","), strings.Join(c.Departures, ","))
+	// Saved on the main loop, as getRunwaysInUse reads the id it sets. Changes are rare.
+	err := SaveRunwayConfig(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error saving runway configuration: %v\n", err)
+	}
+}
+
+// runwaysInUse returns the sorted runway ends used by enough of the movements of the kind.
+func runwaysInUse(movements []*Movement, kind string) []string {
+	counts := make(map[string]int)
+	total := 0
+	for _, m := range movements {
+		if m.Kind == kind && m.Runway != "" {
+			counts[m.Runway]++
+			total++
+		}
+	}
+
+	var rl []string
+	for rw, n := range counts {
+		if float64(n) >= float64(total)*runwayMinShare {
+			rl = append(rl, rw)
+		}
+	}
+	sort.Strings(rl)
+
+	return rl
+}
+
+// getRunwaysInUse returns the current configuration of every tracked airport.
+func getRunwaysInUse() string {
+	var sl []string
+	for ident, ru := range runwayAirportUse {
+		if ru.Current != nil {
+			sl = append(sl, ru.Current.ToJson())
+		} else {
+			sl = append(sl, fmt.Sprintf("{\"airport\": %q, \"since\": null, \"arrivals\": [], \"departures\": []}", ident))
+		}
+	}
+	sort.Strings(sl)
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
+
+// getRunwayHistory returns the current configuration of an airport and its changes since the time.
+func getRunwayHistory(ident string, t time.Time) string {
+	ident = strings.ToUpper(ident)
+
+	current := "null"
+	if ru, ok := runwayAirportUse[ident]; ok && ru.Current != nil {
+		current = ru.Current.ToJson()
+	}
+
+	configs, err := LoadRunwayConfigs(ident, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading runway configurations: %v\n", err)
+	}
+	sl := make([]string, len(configs))
+	for i, c := range configs {
+		sl[i] = c.ToJson()
+	}
+
+	return fmt.Sprintf("{\"airport\": %q, \"current\": %s, \"history\": [%s]}", ident, current, strings.Join(sl, ",\n"))
+}
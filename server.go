@@ -3,7 +3,10 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -14,7 +17,12 @@ import (
 type BoardCmd struct {
 	Cmd   int
 	Icao  uint
-	Since time.Time
+	Arg    string
+	Since  time.Time
+	Filter url.Values
+	Method string
+	Body   string
+	Units  *Units
 }
 
 const (
@@ -22,10 +30,50 @@ const (
 	GetAll
 	GetPlane
 	GetLocations
+	GetCoverage
+	GetCoverageHistory
+	GetAlerts
+	GetFences
+	GetFenceEvents
+	GetAirspaceEvents
+	GetIncursions
+	GetLookup
+	GetFlights
+	GetAirport
+	GetMovements
+	GetRunways
+	GetRunwaysInUse
+	GetPhases
+	GetEvents
+	GetEncounters
+	GetPrediction
+	GetConflicts
+	GetSuspects
+	GetAnomalies
+	GetStateChanges
+	Watchlist
+	Rules
+	GetLogbook
+	GetNew
+	GetNoisePoints
+	GetOverflights
+	GetNoiseDaily
+	GetNoiseMonthly
+	Qnh
 )
 
+// Commands which can respond with CSV, with format=csv, and the name of the file.
+var csvCommands = map[int]string{
+	GetLogbook:      "logbook.csv",
+	GetNoiseDaily:   "noise-daily.csv",
+	GetNoiseMonthly: "noise-monthly.csv",
+}
+
 var zeroTime = time.Time{}
 
+// Largest request body read, in bytes.
+const maxBody = 1 << 16
+
 type Server struct {
 	json chan string
 	cmd  chan<- *BoardCmd
@@ -39,19 +87,21 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	parts := strings.Split(r.URL.Path, "/")[1:]
 	reqCmd := strings.ToLower(parts[0])
-	var icao uint64
-	var err error
-	if len(parts) == 2 {
-		icao, err = strconv.ParseUint(parts[1], 16, 0)
+
+	bc := &BoardCmd{Filter: r.URL.Query(), Method: r.Method}
+	if r.Method == "POST" || r.Method == "PUT" {
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBody))
 		if err != nil {
-			s.badRequest(w, http.StatusBadRequest, fmt.Sprintf("invalid ICAO number: %q", parts[1]), r.URL.Path)
+			s.badRequest(w, http.StatusBadRequest, "unable to read request body", r.URL.Path)
 			return
 		}
+		bc.Body = string(b)
+	}
+	if len(parts) >= 2 {
+		bc.Arg = parts[1]
 	}
 
-	bc := &BoardCmd{Icao: uint(icao)}
-
-	ss := r.URL.Query()["s"]
+	ss := bc.Filter["s"]
 	if len(ss) >= 1 {
 		si, err := strconv.ParseInt(ss[0], 10, 64)
 		if err != nil {
@@ -60,29 +110,251 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		bc.Since = time.Unix(si, 0)
 	}
 
+	bc.Units = findUnits(bc.Filter.Get("units"))
+	if bc.Units == nil {
+		s.badRequest(w, http.StatusBadRequest, fmt.Sprintf("unknown units: %q", bc.Filter.Get("units")), r.URL.Path)
+		return
+	}
+
 	switch reqCmd {
 	case "active":
 		bc.Cmd = GetCurrent
 	case "planes":
-		if icao > 0 {
+		if !s.parseIcao(w, r, bc) {
+			return
+		}
+		if bc.Icao > 0 {
 			bc.Cmd = GetPlane
 		} else {
 			bc.Cmd = GetAll
 		}
 	case "locations":
-		if icao == 0 {
+		if !s.parseIcao(w, r, bc) {
+			return
+		}
+		if bc.Icao == 0 {
 			s.badRequest(w, http.StatusBadRequest, "missing required plane icao number", r.URL.Path)
 			return
 		}
 		bc.Cmd = GetLocations
+	case "coverage":
+		if bc.Arg == "" {
+			bc.Arg = rxName
+		}
+		if len(parts) >= 3 && strings.ToLower(parts[2]) == "history" {
+			bc.Cmd = GetCoverageHistory
+		} else {
+			bc.Cmd = GetCoverage
+		}
+	case "alerts":
+		if !s.parseIcao(w, r, bc) {
+			return
+		}
+		bc.Cmd = GetAlerts
+	case "fences":
+		if bc.Arg == "" {
+			bc.Cmd = GetFences
+		} else {
+			bc.Cmd = GetFenceEvents
+		}
+	case "lookup":
+		if bc.Arg == "" {
+			s.badRequest(w, http.StatusBadRequest, "missing required registration", r.URL.Path)
+			return
+		}
+		bc.Cmd = GetLookup
+	case "flights":
+		if bc.Arg == "" {
+			s.badRequest(w, http.StatusBadRequest, "missing required flight number", r.URL.Path)
+			return
+		}
+		bc.Cmd = GetFlights
+	case "airspace":
+		if strings.ToLower(bc.Arg) == "incursions" {
+			bc.Cmd = GetIncursions
+		} else {
+			bc.Cmd = GetAirspaceEvents
+		}
+	case "airports":
+		if bc.Arg == "" {
+			s.badRequest(w, http.StatusBadRequest, "missing required airport ident", r.URL.Path)
+			return
+		}
+		var sub string
+		if len(parts) >= 3 {
+			sub = strings.ToLower(parts[2])
+		}
+		switch sub {
+		case "movements":
+			bc.Cmd = GetMovements
+		case "runways":
+			bc.Cmd = GetRunways
+		default:
+			bc.Cmd = GetAirport
+		}
+	case "runways":
+		bc.Cmd = GetRunwaysInUse
+	case "phases":
+		if !s.parseIcao(w, r, bc) {
+			return
+		}
+		if bc.Icao == 0 {
+			s.badRequest(w, http.StatusBadRequest, "missing required plane icao number", r.URL.Path)
+			return
+		}
+		bc.Cmd = GetPhases
+	case "events":
+		if !s.parseIcao(w, r, bc) {
+			return
+		}
+		bc.Cmd = GetEvents
+	case "encounters":
+		bc.Cmd = GetEncounters
+	case "predict":
+		if !s.parseIcao(w, r, bc) {
+			return
+		}
+		if bc.Icao == 0 {
+			s.badRequest(w, http.StatusBadRequest, "missing required plane icao number", r.URL.Path)
+			return
+		}
+		bc.Cmd = GetPrediction
+	case "conflicts":
+		bc.Cmd = GetConflicts
+	case "anomalies":
+		if !s.parseIcao(w, r, bc) {
+			return
+		}
+		if bc.Icao == 0 {
+			bc.Cmd = GetSuspects
+		} else {
+			bc.Cmd = GetAnomalies
+		}
+	case "lifecycle":
+		bc.Cmd = GetStateChanges
+	case "watchlist":
+		if !s.checkEdit(w, r, bc, func(body string) error {
+			_, err := parseWatchEntry(body)
+			return err
+		}) {
+			return
+		}
+		bc.Cmd = Watchlist
+	case "rules":
+		if bc.Arg == "test" {
+			if r.Method != "POST" {
+				s.badRequest(w, http.StatusMethodNotAllowed, "method not allowed", r.URL.Path)
+				return
+			}
+			if _, err := parseTestRule(bc.Body); err != nil {
+				s.badRequest(w, http.StatusBadRequest, err.Error(), r.URL.Path)
+				return
+			}
+		} else if !s.checkEdit(w, r, bc, func(body string) error {
+			_, err := parseRule(body)
+			return err
+		}) {
+			return
+		}
+		bc.Cmd = Rules
+	case "logbook":
+		bc.Cmd = GetLogbook
+	case "new":
+		bc.Cmd = GetNew
+	case "noise":
+		var sub string
+		if len(parts) >= 3 {
+			sub = strings.ToLower(parts[2])
+		}
+		switch {
+		case bc.Arg == "":
+			bc.Cmd = GetNoisePoints
+		case sub == "daily":
+			if d := bc.Filter.Get("date"); d != "" {
+				if _, err := time.ParseInLocation(noiseDateLayout, d, time.Local); err != nil {
+					s.badRequest(w, http.StatusBadRequest, fmt.Sprintf("invalid date, expected YYYY-MM-DD: %q", d), r.URL.Path)
+					return
+				}
+			}
+			bc.Cmd = GetNoiseDaily
+		case sub == "monthly":
+			if m := bc.Filter.Get("month"); m != "" {
+				if _, err := time.ParseInLocation(noiseMonthLayout, m, time.Local); err != nil {
+					s.badRequest(w, http.StatusBadRequest, fmt.Sprintf("invalid month, expected YYYY-MM: %q", m), r.URL.Path)
+					return
+				}
+			}
+			bc.Cmd = GetNoiseMonthly
+		default:
+			bc.Cmd = GetOverflights
+		}
+	case "qnh":
+		switch r.Method {
+		case "GET":
+		case "POST":
+			if _, err := parseQnhBody(bc.Body); err != nil {
+				s.badRequest(w, http.StatusBadRequest, err.Error(), r.URL.Path)
+				return
+			}
+		default:
+			s.badRequest(w, http.StatusMethodNotAllowed, "method not allowed", r.URL.Path)
+			return
+		}
+		bc.Cmd = Qnh
 	default:
 		http.ServeFile(w, r, "www" + r.URL.Path)
 		return
 	}
 	s.cmd <- bc
+	if name, ok := csvCommands[bc.Cmd]; ok && bc.Filter.Get("format") == "csv" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		s.writeContent(<-s.json, "text/csv", w)
+		return
+	}
 	s.writeResponse(<-s.json, w)
 }
 
+// checkEdit checks the method of a request to a list which can be edited, and parses the
+// body of a POST or PUT. An id is required to PUT or DELETE. Returns false if the request is
+// invalid, in which case a bad request has been written.
+func (s *Server) checkEdit(w http.ResponseWriter, r *http.Request, bc *BoardCmd, parse func(string) error) bool {
+	switch r.Method {
+	case "GET":
+	case "POST", "PUT", "DELETE":
+		if r.Method != "POST" && bc.Arg == "" {
+			s.badRequest(w, http.StatusBadRequest, "missing required id", r.URL.Path)
+			return false
+		}
+		if r.Method == "DELETE" {
+			break
+		}
+		if err := parse(bc.Body); err != nil {
+			s.badRequest(w, http.StatusBadRequest, err.Error(), r.URL.Path)
+			return false
+		}
+	default:
+		s.badRequest(w, http.StatusMethodNotAllowed, "method not allowed", r.URL.Path)
+		return false
+	}
+	return true
+}
+
+// parseIcao reads the hex ICAO number from the second part of the path, if present.
+// Returns false if the value was invalid, in which case a bad request has been written.
+func (s *Server) parseIcao(w http.ResponseWriter, r *http.Request, bc *BoardCmd) bool {
+	if bc.Arg == "" {
+		return true
+	}
+
+	icao, err := strconv.ParseUint(bc.Arg, 16, 0)
+	if err != nil {
+		s.badRequest(w, http.StatusBadRequest, fmt.Sprintf("invalid ICAO number: %q", bc.Arg), r.URL.Path)
+		return false
+	}
+	bc.Icao = uint(icao)
+	return true
+}
+
 func (s *Server) badRequest(w http.ResponseWriter, status int, errMsg string, path string) {
 	w.WriteHeader(status)
 	fmt.Fprint(w, errMsg)
@@ -90,8 +362,12 @@ func (s *Server) badRequest(w http.ResponseWriter, status int, errMsg string, pa
 }
 
 func (s *Server) writeResponse(resp string, w http.ResponseWriter) {
+	s.writeContent(resp, "application/json", w)
+}
+
+func (s *Server) writeContent(resp string, contentType string, w http.ResponseWriter) {
 	h := w.Header()
-	h.Set("Content-Type", "application/json")
+	h.Set("Content-Type", contentType)
 	buf := bufio.NewWriter(w)
 	_, err := buf.WriteString(resp)
 	if err != nil {
@@ -128,14 +404,61 @@ func StartServer(c chan<- *BoardCmd) chan<- string {
 }
 
 
-func currentPlanes(t time.Time) string {
+// planeMatches returns true if the plane passes each of the filters in the request query.
+func planeMatches(pl *Plane, filter url.Values) bool {
+	if c := filter.Get("country"); c != "" {
+		if !strings.EqualFold(c, pl.Country) && !strings.EqualFold(c, pl.Flag) {
+			return false
+		}
+	}
+	if m := filter.Get("military"); m != "" {
+		if mil, err := strconv.ParseBool(m); err == nil && mil != pl.Military {
+			return false
+		}
+	}
+	if s := filter.Get("state"); s != "" {
+		// Planes which aren't active have no state, and are reported as archived.
+		state := pl.State
+		if state == "" {
+			state = StateArchived
+		}
+		found := false
+		for _, st := range strings.Split(s, ",") {
+			if strings.EqualFold(strings.TrimSpace(st), state) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if wt := filter.Get("watched"); wt != "" {
+		if watched, err := strconv.ParseBool(wt); err == nil && watched != (pl.Watched != nil) {
+			return false
+		}
+	}
+	if p := filter.Get("phase"); p != "" {
+		found := false
+		for _, ph := range strings.Split(p, ",") {
+			if strings.EqualFold(strings.TrimSpace(ph), pl.Phase) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func currentPlanes(t time.Time, filter url.Values) string {
 	buf := bytes.Buffer{}
 
 	buf.WriteString("[")
 
 	sl := []string{}
 	for _, pl := range planeCache {
-		if t == zeroTime || pl.LastSeen.After(t) {
+		if (t == zeroTime || pl.LastSeen.After(t)) && planeMatches(pl, filter) {
 			sl = append(sl, pl.ToJson())
 		}
 	}
@@ -146,11 +469,11 @@ func currentPlanes(t time.Time) string {
 	return buf.String()
 }
 
-func getAllPlanes(t time.Time) string {
+func getAllPlanes(t time.Time, filter url.Values) string {
 	buf := bytes.Buffer{}
 
 	buf.WriteString("{\"current\": ")
-	buf.WriteString(currentPlanes(t))
+	buf.WriteString(currentPlanes(t, filter))
 
 	buf.WriteString(",\n\"past\": [")
 
@@ -159,9 +482,28 @@ func getAllPlanes(t time.Time) string {
 		fmt.Fprintf(os.Stderr, "error loading planes: %v\n", err)
 		return "[]"
 	}
-	sl := []string{}
+	var past []*Plane
+	var callsigns []string
 	for _, pl := range planes {
 		if t == zeroTime || pl.LastSeen.After(t) {
+			past = append(past, pl)
+			if pl.CallSign != "" {
+				callsigns = append(callsigns, strings.ToUpper(pl.CallSign))
+			}
+		}
+	}
+
+	// The reference data is loaded with the planes, and the routes all at once.
+	routes, err := LoadRoutes(callsigns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading routes: %v\n", err)
+	}
+	sl := []string{}
+	for _, pl := range past {
+		addDerivedInfo(pl)
+		pl.Route = routes[strings.ToUpper(pl.CallSign)]
+		pl.Watched = matchWatchlist(pl)
+		if planeMatches(pl, filter) {
 			sl = append(sl, pl.ToJson())
 		}
 	}
@@ -176,6 +518,10 @@ func detailedPlane(icao uint) string {
 	if err != nil {
 		return ""
 	}
+	if _, ok := planeCache[icao]; !ok {
+		enrichPlane(pl)
+		pl.Watched = matchWatchlist(pl)
+	}
 
 	return pl.ToJson()
 }
@@ -200,7 +546,8 @@ func getPlaneLocations(icao uint, t time.Time) string {
 	buf.WriteString("[")
 
 	for i, l := range locs {
-		ll[i] = fmt.Sprintf("{\"id\": %d, \"latitude\": %f, \"longitude\": %f, \"time\": %q}", l.id, l.Latitude, l.Longitude, l.Time.String())
+		ll[i] = fmt.Sprintf("{\"id\": %d, \"latitude\": %f, \"longitude\": %f, %s, \"time\": %q}", l.id, l.Latitude, l.Longitude,
+			units.altitude("altitude", l.Altitude), l.Time.String())
 	}
 
 	buf.WriteString(strings.Join(ll, ",\n"))
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sink delivers a JSON encoded notification somewhere outside of tamer.
+type Sink interface {
+	Name() string
+	Deliver(payload string) error
+}
+
+// WebhookSink POSTs the notification to a URL.
+type WebhookSink struct {
+	URL string
+}
+
+var webhookClient = &http.Client{Timeout: time.Second * 10}
+
+func (s *WebhookSink) Name() string {
+	return "webhook:" + s.URL
+}
+
+func (s *WebhookSink) Deliver(payload string) error {
+	resp, err := webhookClient.Post(s.URL, "application/json", strings.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "unable to post webhook")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status: %s", resp.Status)
+	}
+	return nil
+}
+
+// ExecSink runs a command with the shell, passing the notification on stdin.
+type ExecSink struct {
+	Command string
+}
+
+func (s *ExecSink) Name() string {
+	return "exec:" + s.Command
+}
+
+func (s *ExecSink) Deliver(payload string) error {
+	cmd := exec.Command("sh", "-c", s.Command)
+	cmd.Stdin = strings.NewReader(payload)
+	cmd.Env = append(os.Environ(), "TAMER_EVENT="+payload)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("command failed: %q", out))
+	}
+	return nil
+}
+
+// FileSink appends each notification as a single line to a file.
+type FileSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *FileSink) Name() string {
+	return "file:" + s.Path
+}
+
+func (s *FileSink) Deliver(payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to open log file")
+	}
+
+	_, err = fmt.Fprintln(f, strings.Replace(payload, "\n", " ", -1))
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "unable to write log file")
+	}
+	return f.Close()
+}
+
+// deliver sends the payload to each of the sinks without blocking the caller.
+func deliver(sinks []Sink, payload string) {
+	for _, s := range sinks {
+		go func(s Sink) {
+			err := s.Deliver(payload)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error delivering to %s: %v\n", s.Name(), err)
+			}
+		}(s)
+	}
+}
+
+// MqttSink publishes the notification to a topic on an MQTT broker. It connects for each
+// notification and publishes at most once (QoS 0), which is all alerts need.
+type MqttSink struct {
+	Addr  string // host:port of the broker
+	Topic string
+}
+
+// Number of connections made to MQTT brokers, used to make the client IDs unique.
+var mqttConnections uint64
+
+func (s *MqttSink) Name() string {
+	return "mqtt://" + s.Addr + "/" + s.Topic
+}
+
+func (s *MqttSink) Deliver(payload string) error {
+	conn, err := net.DialTimeout("tcp", s.Addr, time.Second*10)
+	if err != nil {
+		return errors.Wrap(err, "unable to connect to MQTT broker")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 10))
+
+	// CONNECT with MQTT 3.1.1, a clean session and a 60 second keep alive.
+	connect := append(mqttString("MQTT"), 4, 0x02, 0, 60)
+	// Each connection needs its own client ID, as the broker drops the older of two
+	// connections with the same ID and notifications are delivered concurrently.
+	id := atomic.AddUint64(&mqttConnections, 1)
+	connect = append(connect, mqttString(fmt.Sprintf("tamer-%d-%d", os.Getpid(), id))...)
+	_, err = conn.Write(mqttPacket(0x10, connect))
+	if err != nil {
+		return errors.Wrap(err, "unable to send MQTT connect")
+	}
+
+	ack := make([]byte, 4)
+	_, err = io.ReadFull(conn, ack)
+	if err != nil {
+		return errors.Wrap(err, "unable to read MQTT connect acknowledgement")
+	}
+	if ack[0] != 0x20 || ack[3] != 0 {
+		return fmt.Errorf("MQTT broker refused connection: %d", ack[3])
+	}
+
+	_, err = conn.Write(mqttPacket(0x30, append(mqttString(s.Topic), payload...)))
+	if err != nil {
+		return errors.Wrap(err, "unable to send MQTT publish")
+	}
+
+	_, err = conn.Write([]byte{0xe0, 0}) // DISCONNECT
+	return err
+}
+
+// mqttString encodes a string with its length first.
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// mqttPacket adds the fixed header, with the remaining length as a variable length integer.
+func mqttPacket(header byte, body []byte) []byte {
+	p := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		p = append(p, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+// parseSink creates a sink from its name: webhook:<url>, exec:<command>, file:<path> or
+// mqtt://<host>[:port]/<topic>.
+func parseSink(spec string) (Sink, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case strings.HasPrefix(spec, "webhook:"):
+		return &WebhookSink{URL: strings.TrimPrefix(spec, "webhook:")}, nil
+	case strings.HasPrefix(spec, "exec:"):
+		return &ExecSink{Command: strings.TrimPrefix(spec, "exec:")}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return &FileSink{Path: strings.TrimPrefix(spec, "file:")}, nil
+	case strings.HasPrefix(spec, "mqtt://"):
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid MQTT sink: %q", spec)
+		}
+		topic := strings.TrimPrefix(u.Path, "/")
+		if topic == "" {
+			return nil, fmt.Errorf("missing MQTT topic: %q", spec)
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr += ":1883"
+		}
+		return &MqttSink{Addr: addr, Topic: topic}, nil
+	}
+	return nil, fmt.Errorf("unknown sink: %q", spec)
+}
+
+// Sinks configured on the command line which rules can notify, by name. Rules can only
+// name these, so sinks which run commands or write files can't be created over HTTP.
+var namedSinks = make(map[string]Sink)
+
+// sinkFlags is the value of the repeatable -sink flag, each name=spec.
+type sinkFlags []string
+
+func (f *sinkFlags) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *sinkFlags) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// loadNamedSinks creates the sinks from their name=spec definitions.
+func loadNamedSinks(defs []string) error {
+	for _, d := range defs {
+		i := strings.Index(d, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid sink %q, expected name=spec", d)
+		}
+		name := strings.TrimSpace(d[:i])
+		if _, ok := namedSinks[name]; ok {
+			return fmt.Errorf("duplicate sink name: %q", name)
+		}
+		s, err := parseSink(d[i+1:])
+		if err != nil {
+			return err
+		}
+		namedSinks[name] = s
+	}
+	return nil
+}
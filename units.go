@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Units is a system of units for the values in responses. Values are kept in aviation
+// units, feet, knots, feet per minute and nautical miles, and converted when written.
+type Units struct {
+	Name     string
+	Altitude string // ft or m, also used for heights and lengths
+	Speed    string // kt, km/h or mph
+	Vertical string // ft/min or m/s
+	Distance string // nm or km
+}
+
+var unitSystems = map[string]*Units{
+	"aviation": {Name: "aviation", Altitude: "ft", Speed: "kt", Vertical: "ft/min", Distance: "nm"},
+	"imperial": {Name: "imperial", Altitude: "ft", Speed: "mph", Vertical: "ft/min", Distance: "nm"},
+	"metric":   {Name: "metric", Altitude: "m", Speed: "km/h", Vertical: "m/s", Distance: "km"},
+}
+
+var (
+	// Units used when a request doesn't ask for any.
+	defaultUnits = unitSystems["aviation"]
+	// Units of the response being written by handleCommand, otherwise the default units.
+	// Only read and written on the main loop, so anything run in another goroutine must
+	// use the default units or a JSON string built beforehand.
+	units = defaultUnits
+)
+
+// findUnits returns the system of units with the name, or the default units if the name
+// is empty. Returns nil if there is no such system.
+func findUnits(name string) *Units {
+	if name == "" {
+		return defaultUnits
+	}
+	return unitSystems[strings.ToLower(name)]
+}
+
+// unitField returns a JSON value labelled with its unit.
+func unitField(key, value, unit string) string {
+	return fmt.Sprintf("%q: %s, %q: %q", key, value, key+"Unit", unit)
+}
+
+// csvUnit returns a unit for use in the name of a CSV column, such as km_h for km/h.
+func csvUnit(unit string) string {
+	return strings.Replace(unit, "/", "_", -1)
+}
+
+// altitudeValue converts an altitude, height or length in feet.
+func (u *Units) altitudeValue(ft int) string {
+	if u.Altitude == "m" {
+		return fmt.Sprintf("%d", int(math.Round(float64(ft)*0.3048)))
+	}
+	return fmt.Sprintf("%d", ft)
+}
+
+func (u *Units) speedValue(kt float64) string {
+	switch u.Speed {
+	case "km/h":
+		kt *= 1.852
+	case "mph":
+		kt *= 1.150779
+	}
+	return fmt.Sprintf("%.2f", kt)
+}
+
+func (u *Units) verticalValue(fpm int) string {
+	if u.Vertical == "m/s" {
+		return fmt.Sprintf("%.2f", float64(fpm)*0.00508)
+	}
+	return fmt.Sprintf("%d", fpm)
+}
+
+func (u *Units) distanceValue(nm float64) string {
+	if u.Distance == "km" {
+		nm *= 1.852
+	}
+	return fmt.Sprintf("%.2f", nm)
+}
+
+func (u *Units) altitude(key string, ft int) string {
+	return unitField(key, u.altitudeValue(ft), u.Altitude)
+}
+
+func (u *Units) speed(key string, kt float64) string {
+	return unitField(key, u.speedValue(kt), u.Speed)
+}
+
+func (u *Units) vertical(key string, fpm int) string {
+	return unitField(key, u.verticalValue(fpm), u.Vertical)
+}
+
+func (u *Units) distance(key string, nm float64) string {
+	return unitField(key, u.distanceValue(nm), u.Distance)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// An aircraft never seen before.
+	SightingFirst = "first"
+	// An aircraft not seen for the new-after period.
+	SightingReturning = "returning"
+)
+
+// Sighting is an aircraft which is new, either never seen before or not seen for a while.
+type Sighting struct {
+	id       int
+	Icao     uint
+	Time     time.Time
+	Kind     string
+	Previous time.Time // when it was last seen before, zero for a first sighting
+}
+
+func (s *Sighting) ToJson(pl *Plane) string {
+	prev := "null"
+	if !s.Previous.IsZero() {
+		prev = fmt.Sprintf("%q", s.Previous.String())
+	}
+	plane := "null"
+	if pl != nil {
+		plane = pl.ToJson()
+	}
+	return fmt.Sprintf("{\"id\": %d, \"icao\": \"%06X\", \"time\": %q, \"kind\": %q, \"previous\": %s, \"plane\": %s}",
+		s.id, s.Icao, s.Time.String(), s.Kind, prev, plane)
+}
+
+// startVisit counts a new visit of a plane which has just become active, and raises a
+// sighting if it is new. The plane's LastSeen is still from its previous visit.
+func startVisit(pl *Plane, t time.Time) {
+	prev := pl.LastSeen
+	if !prev.IsZero() {
+		// Planes saved before visits were counted have been seen at least once.
+		if pl.FirstSeen.IsZero() {
+			pl.FirstSeen = prev
+		}
+		if pl.Visits == 0 {
+			pl.Visits = 1
+		}
+	} else {
+		pl.FirstSeen = t
+	}
+	pl.Visits++
+	pl.Visit = t
+
+	var kind string
+	switch {
+	case prev.IsZero():
+		kind = SightingFirst
+	case newAfter > 0 && t.Sub(prev) >= newAfter:
+		kind = SightingReturning
+	default:
+		return
+	}
+
+	s := &Sighting{Icao: pl.Icao, Time: t, Kind: kind, Previous: prev}
+	if verbose {
+		fmt.Printf("New aircraft: %06X %s\n", s.Icao, s.Kind)
+	}
+	publish(&BusEvent{Kind: BusNew, Icao: pl.Icao, Time: t, Field: "sighting", New: kind})
+	go func() {
+		err := SaveSighting(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving sighting: %v\n", err)
+		}
+	}()
+}
+
+// totalTime returns the time the plane has been observed over all its visits.
+func totalTime(pl *Plane) time.Duration {
+	total := pl.TotalTime
+	if !pl.Visit.IsZero() && pl.LastSeen.After(pl.Visit) {
+		total += pl.LastSeen.Sub(pl.Visit)
+	}
+	return total
+}
+
+// getNewAircraft returns the new aircraft since the time, or since midnight if none is given.
+func getNewAircraft(t time.Time) string {
+	if t == zeroTime {
+		y, m, d := time.Now().Date()
+		t = time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	}
+
+	sightings, err := LoadSightings(t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading sightings: %v\n", err)
+		return "[]"
+	}
+
+	sl := make([]string, len(sightings))
+	for i, s := range sightings {
+		pl, ok := planeCache[s.Icao]
+		if !ok {
+			pl, err = getPlaneByIcao(s.Icao)
+			if err != nil {
+				pl = nil
+			} else {
+				enrichPlane(pl)
+				pl.Watched = matchWatchlist(pl)
+			}
+		}
+		sl[i] = s.ToJson(pl)
+	}
+
+	return "[" + strings.Join(sl, ",\n") + "]"
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WatchIcao         = "icao"
+	WatchRegistration = "registration"
+	WatchCallSign     = "callsign"
+)
+
+// AlertWatch is the kind of alert raised when a watched aircraft appears.
+const AlertWatch = "watchlist"
+
+// WatchEntry is an aircraft on the watchlist. The value is a hex ICAO address, or a
+// registration or callsign which may contain shell style wildcards such as RCH*.
+type WatchEntry struct {
+	id    int
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+	Label string `json:"label"`
+	Note  string `json:"note"`
+}
+
+func (w *WatchEntry) ToJson() string {
+	return fmt.Sprintf("{\"id\": %d, \"kind\": %q, \"value\": %q, \"label\": %q, \"note\": %q}", w.id, w.Kind, w.Value, w.Label, w.Note)
+}
+
+// Matches returns true if the plane is the aircraft watched for.
+func (w *WatchEntry) Matches(pl *Plane) bool {
+	switch w.Kind {
+	case WatchIcao:
+		icao, err := strconv.ParseUint(w.Value, 16, 0)
+		return err == nil && uint(icao) == pl.Icao
+	case WatchRegistration:
+		return globMatch(w.Value, pl.Info.Registration)
+	case WatchCallSign:
+		return globMatch(w.Value, pl.CallSign)
+	}
+	return false
+}
+
+func globMatch(pattern, s string) bool {
+	if s == "" {
+		return false
+	}
+	ok, err := path.Match(strings.ToUpper(pattern), strings.ToUpper(s))
+	return err == nil && ok
+}
+
+// parseWatchEntry reads a watchlist entry from a JSON request body and checks it is valid.
+func parseWatchEntry(body string) (*WatchEntry, error) {
+	w := new(WatchEntry)
+	err := json.Unmarshal([]byte(body), w)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid watchlist entry")
+	}
+
+	w.Kind = strings.ToLower(strings.TrimSpace(w.Kind))
+	w.Value = strings.ToUpper(strings.TrimSpace(w.Value))
+	switch w.Kind {
+	case WatchIcao:
+		if _, err := strconv.ParseUint(w.Value, 16, 0); err != nil {
+			return nil, fmt.Errorf("invalid ICAO number: %q", w.Value)
+		}
+	case WatchRegistration, WatchCallSign:
+		if _, err := path.Match(w.Value, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern: %q", w.Value)
+		}
+	default:
+		return nil, fmt.Errorf("kind must be %s, %s or %s", WatchIcao, WatchRegistration, WatchCallSign)
+	}
+	if w.Value == "" {
+		return nil, errors.New("missing value")
+	}
+	return w, nil
+}
+
+var watchlist []*WatchEntry
+
+func loadWatchlist() error {
+	var err error
+	watchlist, err = LoadWatchlist()
+	return err
+}
+
+// matchWatchlist returns the first watchlist entry the plane matches, or nil if none do.
+func matchWatchlist(pl *Plane) *WatchEntry {
+	for _, w := range watchlist {
+		if w.Matches(pl) {
+			return w
+		}
+	}
+	return nil
+}
+
+// checkWatchlist tags an active plane with the watchlist entry it matches and raises an
+// alert the first time it does.
+func checkWatchlist(pl *Plane, t time.Time) {
+	if pl.Watched != nil {
+		return
+	}
+
+	if w := matchWatchlist(pl); w != nil {
+		pl.Watched = w
+		name := w.Label
+		if name == "" {
+			name = w.Value
+		}
+		raiseAlert(newAlert(pl, AlertWatch+": "+name, AlertEnter, t))
+	}
+}
+
+// untagPlanes removes the entry from the planes it is tagged on. They are checked against the
+// watchlist again on their next message.
+func untagPlanes(w *WatchEntry) {
+	for _, pl := range planeCache {
+		if pl.Watched == w {
+			pl.Watched = nil
+		}
+	}
+}
+
+func findWatchEntry(id int) (int, *WatchEntry) {
+	for i, w := range watchlist {
+		if w.id == id {
+			return i, w
+		}
+	}
+	return -1, nil
+}
+
+// watchlistCommand lists, reads, creates, updates or deletes watchlist entries depending on
+// the HTTP method. Returns null for an unknown entry.
+func watchlistCommand(method, arg, body string) string {
+	id, _ := strconv.Atoi(arg)
+	i, w := findWatchEntry(id)
+
+	switch method {
+	case "POST":
+		nw, err := parseWatchEntry(body)
+		if err != nil {
+			return "null"
+		}
+		err = SaveWatchEntry(nw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving watchlist entry: %v\n", err)
+			return "null"
+		}
+		watchlist = append(watchlist, nw)
+		return nw.ToJson()
+	case "PUT":
+		nw, err := parseWatchEntry(body)
+		if w == nil || err != nil {
+			return "null"
+		}
+		nw.id = w.id
+		err = SaveWatchEntry(nw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error saving watchlist entry: %v\n", err)
+			return "null"
+		}
+		untagPlanes(w)
+		watchlist[i] = nw
+		return nw.ToJson()
+	case "DELETE":
+		if w == nil {
+			return "null"
+		}
+		err := DeleteWatchEntry(w.id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error deleting watchlist entry: %v\n", err)
+			return "null"
+		}
+		untagPlanes(w)
+		watchlist = append(watchlist[:i], watchlist[i+1:]...)
+		return w.ToJson()
+	}
+
+	if arg != "" {
+		if w == nil {
+			return "null"
+		}
+		return w.ToJson()
+	}
+
+	sl := make([]string, len(watchlist))
+	for i, w := range watchlist {
+		sl[i] = w.ToJson()
+	}
+	return "[" + strings.Join(sl, ",\n") + "]"
+}